@@ -0,0 +1,75 @@
+package main
+
+import (
+   "fmt"
+   "github.com/GlenKelley/portal/render"
+)
+
+//screenshot and frame sequence requests, served after Draw
+type CaptureState struct {
+   Screenshot bool
+   Remaining  int  //ticks left to record
+   Tick       int  //ticks recorded in the current sequence
+   Pending    bool //a tick has run since the last saved frame
+   Sequence   int
+   Shots      int
+}
+
+func (r *Receiver) Screenshot() {
+   r.Capture.Screenshot = true
+   r.Invalid = true
+}
+
+//records one numbered frame per simulation tick for CaptureFrames ticks
+func (r *Receiver) RecordFrames() {
+   if r.Recording() {
+      return
+   }
+   r.Capture.Remaining = r.Constants.CaptureFrames
+   r.Capture.Tick = 0
+   r.Capture.Sequence++
+}
+
+func (r *Receiver) Recording() bool {
+   return r.Capture.Remaining > 0 || r.Capture.Pending
+}
+
+//counts a recorded tick, called at the end of each simulation step
+func (r *Receiver) CaptureTick() {
+   if r.Capture.Remaining > 0 {
+      r.Capture.Remaining--
+      r.Capture.Tick++
+      r.Capture.Pending = true
+      r.Invalid = true
+   }
+}
+
+//saves whatever was requested of the frame just drawn
+func (r *Receiver) CaptureDrawn() {
+   if !r.Capture.Screenshot && !r.Capture.Pending {
+      return
+   }
+   snapshot := r.Renderer.Snapshot()
+   if r.Capture.Screenshot {
+      r.Capture.Screenshot = false
+      r.Capture.Shots++
+      r.reportCapture(r.SaveSnapshot(snapshot, render.ScreenshotName(r.Capture.Shots)))
+   }
+   //frames are numbered by tick, so a tick the loop never drew leaves a gap
+   if r.Capture.Pending {
+      name := render.FrameName(r.Capture.Sequence, r.Capture.Tick)
+      r.reportCapture(r.SaveSnapshot(snapshot, name))
+      r.Capture.Pending = false
+   }
+}
+
+func (r *Receiver) reportCapture(err error) {
+   if err != nil {
+      fmt.Println("capture failed:", err)
+   }
+}
+
+//writes a snapshot into the capture directory
+func (r *Receiver) SaveSnapshot(snapshot *render.Snapshot, name string) error {
+   return snapshot.Save(r.Constants.CaptureDirectory, name, r.Constants.CaptureBuffers)
+}
@@ -0,0 +1,18 @@
+#version 150
+uniform sampler2D frame;
+uniform vec2 texel;
+uniform float blur;
+uniform float depth;
+in vec2 texcoord;
+out vec4 fragColor;
+void main()
+{
+    vec4 sum = vec4(0);
+    for (int x = -2; x <= 2; x++) {
+        for (int y = -2; y <= 2; y++) {
+            sum += texture(frame, texcoord + vec2(x, y) * texel * blur);
+        }
+    }
+    fragColor = sum / 25.0;
+    gl_FragDepth = depth;
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+   gl "github.com/GlenKelley/go-gl/gl32"
+)
+
+type FallbackBindings struct {
+   Position gl.AttributeLocation `gl:"position"`
+   Frame gl.UniformLocation `gl:"frame"`
+   Texel gl.UniformLocation `gl:"texel"`
+   Blur gl.UniformLocation `gl:"blur"`
+   Depth gl.UniformLocation `gl:"depth"`
+}
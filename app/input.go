@@ -0,0 +1,47 @@
+package main
+
+import (
+   "fmt"
+   glm "github.com/Jragonmiris/mathgl"
+)
+
+//the most fixed steps one call to Simulate will run
+const MaxStepsPerFrame = 8
+
+//replays or records the world's input as the constants ask
+func (r *Receiver) OpenInput() error {
+   if r.Constants.TimeStep <= 0 {
+      return fmt.Errorf("time step %v must be positive", r.Constants.TimeStep)
+   }
+   if r.Constants.InputReplay != "" {
+      err := r.World.Input.Replay(r.Constants.InputReplay)
+      if err != nil {
+         return err
+      }
+   }
+   if r.Constants.InputRecord != "" {
+      return r.World.Input.Record(r.Constants.InputRecord)
+   }
+   return nil
+}
+
+func (r *Receiver) MoveUp()           { r.World.Issue("MoveUp", glm.Vec2d{}) }
+func (r *Receiver) StopMoveUp()       { r.World.Issue("StopMoveUp", glm.Vec2d{}) }
+func (r *Receiver) MoveDown()         { r.World.Issue("MoveDown", glm.Vec2d{}) }
+func (r *Receiver) StopMoveDown()     { r.World.Issue("StopMoveDown", glm.Vec2d{}) }
+func (r *Receiver) MoveForward()      { r.World.Issue("MoveForward", glm.Vec2d{}) }
+func (r *Receiver) StopMoveForward()  { r.World.Issue("StopMoveForward", glm.Vec2d{}) }
+func (r *Receiver) MoveBackward()     { r.World.Issue("MoveBackward", glm.Vec2d{}) }
+func (r *Receiver) StopMoveBackward() { r.World.Issue("StopMoveBackward", glm.Vec2d{}) }
+func (r *Receiver) StrafeLeft()       { r.World.Issue("StrafeLeft", glm.Vec2d{}) }
+func (r *Receiver) StopStrafeLeft()   { r.World.Issue("StopStrafeLeft", glm.Vec2d{}) }
+func (r *Receiver) StrafeRight()      { r.World.Issue("StrafeRight", glm.Vec2d{}) }
+func (r *Receiver) StopStrafeRight()  { r.World.Issue("StopStrafeRight", glm.Vec2d{}) }
+func (r *Receiver) Jump()             { r.World.Issue("Jump", glm.Vec2d{}) }
+func (r *Receiver) PlacePortalA()     { r.World.Issue("PlacePortalA", glm.Vec2d{}) }
+func (r *Receiver) PlacePortalB()     { r.World.Issue("PlacePortalB", glm.Vec2d{}) }
+
+func (r *Receiver) PanView(pos, delta glm.Vec2d) {
+   r.World.Issue("PanView", delta)
+   r.Invalid = true
+}
@@ -1,27 +1,38 @@
 #version 150
 
-uniform sampler2D textures[2];
+uniform sampler2D diffuseTexture;
+uniform vec4 diffuse;
+uniform float material;
+uniform float textured;
 uniform float glow;
 
-in vec2 texcoord;
-in float fade_factor;
+in vec2 uv;
+in vec3 worldNormal;
 in vec4 worldCoord;
 in vec4 inceptionCoord;
 out vec4 fragColor;
 
+const vec3 light = normalize(vec3(0.3, 1, 0.5));
+const float ambient = 0.35;
+
 void main()
 {
-    fragColor = mix(
-        texture(textures[0], texcoord),
-        texture(textures[1], texcoord),
-        fade_factor
-    );
     vec3 i = inceptionCoord.xyz;
     //vec3 v = vec3(0.1,0.5,0.1) * inceptionCoord.xyz + vec3(0.5,0,0.5)
-    vec3 v = clamp(sin(vec3(0.1,0.5,0.1) * i) + vec3(0.5,0,0.5),0,1);
+    vec4 color = vec4(clamp(sin(vec3(0.1,0.5,0.1) * i) + vec3(0.5,0,0.5),0,1), 1);
+    if (material > 0) {
+        color = diffuse;
+        if (textured > 0) {
+            color *= texture(diffuseTexture, uv);
+        }
+        if (length(worldNormal) > 1e-9) {
+            float lit = ambient + (1 - ambient) * max(0, dot(normalize(worldNormal), light));
+            color.rgb *= lit;
+        }
+    }
     fragColor = mix(
-        vec4(v, 1),
+        color,
         vec4(0,1,0,1),
         glow);
     gl_FragDepth = mix(gl_FragCoord.z, 0, glow);
-}
\ No newline at end of file
+}
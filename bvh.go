@@ -0,0 +1,363 @@
+package portal
+
+import (
+	"fmt"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"sort"
+)
+
+type AABB struct {
+	Min glm.Vec4d
+	Max glm.Vec4d
+}
+
+func EmptyAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{glm.Vec4d{inf, inf, inf, 1}, glm.Vec4d{-inf, -inf, -inf, 1}}
+}
+
+func (b AABB) Add(p glm.Vec4d) AABB {
+	for i := 0; i < 3; i++ {
+		b.Min[i] = math.Min(b.Min[i], p[i])
+		b.Max[i] = math.Max(b.Max[i], p[i])
+	}
+	return b
+}
+
+// Empty reports whether the box holds no points, like EmptyAABB.
+func (b AABB) Empty() bool {
+	for i := 0; i < 3; i++ {
+		if b.Min[i] > b.Max[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (b AABB) Union(o AABB) AABB {
+	if o.Empty() {
+		return b
+	}
+	if b.Empty() {
+		return o
+	}
+	return b.Add(o.Min).Add(o.Max)
+}
+
+func (b AABB) Expand(r float64) AABB {
+	for i := 0; i < 3; i++ {
+		b.Min[i] -= r
+		b.Max[i] += r
+	}
+	return b
+}
+
+func (b AABB) Center() glm.Vec4d {
+	return b.Min.Add(b.Max).Mul(0.5)
+}
+
+func (b AABB) Overlaps(o AABB) bool {
+	for i := 0; i < 3; i++ {
+		if b.Max[i] < o.Min[i] || o.Max[i] < b.Min[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b AABB) Contains(p glm.Vec4d) bool {
+	for i := 0; i < 3; i++ {
+		if p[i] < b.Min[i] || p[i] > b.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rayEntry is where the ray origin + t*dir enters the box, if it does so
+// before maxT.
+func (b AABB) rayEntry(origin, dir glm.Vec4d, maxT float64) (float64, bool) {
+	tmin, tmax := 0.0, maxT
+	for i := 0; i < 3; i++ {
+		if math.Abs(dir[i]) < 1e-15 {
+			if origin[i] < b.Min[i] || origin[i] > b.Max[i] {
+				return 0, false
+			}
+			continue
+		}
+		inv := 1 / dir[i]
+		t0 := (b.Min[i] - origin[i]) * inv
+		t1 := (b.Max[i] - origin[i]) * inv
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tmin = math.Max(tmin, t0)
+		tmax = math.Min(tmax, t1)
+		if tmin > tmax {
+			return 0, false
+		}
+	}
+	return tmin, true
+}
+
+func (t *Triangle) Bounds() AABB {
+	return EmptyAABB().Add(t.A).Add(t.B).Add(t.C)
+}
+
+// Group is a contiguous run of scene triangles belonging to one model, which
+// moves as a unit.
+type Group struct {
+	Name  string
+	First int
+	Count int
+}
+
+// bvhLeafSize is the most items a leaf node holds.
+const bvhLeafSize = 4
+
+type bvhNode struct {
+	Bounds AABB
+	Left   int // child node indices, unused in leaves
+	Right  int
+	First  int // range of items held by a leaf
+	Count  int
+}
+
+// bvhTree is a binary tree of bounds over a set of items.
+type bvhTree struct {
+	nodes []bvhNode
+	items []int
+}
+
+func buildTree(items []int, bounds func(int) AABB) bvhTree {
+	t := bvhTree{items: items}
+	if len(items) == 0 {
+		return t
+	}
+	max := 0
+	for _, item := range items {
+		if item > max {
+			max = item
+		}
+	}
+	boxes := make([]AABB, max+1)
+	for _, item := range items {
+		boxes[item] = bounds(item)
+	}
+	t.build(0, len(items), func(i int) AABB { return boxes[i] })
+	return t
+}
+
+func (t *bvhTree) build(first, count int, bounds func(int) AABB) int {
+	box := EmptyAABB()
+	centers := EmptyAABB()
+	for _, item := range t.items[first : first+count] {
+		b := bounds(item)
+		box = box.Union(b)
+		centers = centers.Add(b.Center())
+	}
+	n := len(t.nodes)
+	t.nodes = append(t.nodes, bvhNode{box, -1, -1, first, count})
+	if count <= bvhLeafSize {
+		return n
+	}
+	axis := 0
+	extent := centers.Max.Sub(centers.Min)
+	for i := 1; i < 3; i++ {
+		if extent[i] > extent[axis] {
+			axis = i
+		}
+	}
+	items := t.items[first : first+count]
+	sort.Slice(items, func(i, j int) bool {
+		return bounds(items[i]).Center()[axis] < bounds(items[j]).Center()[axis]
+	})
+	half := count / 2
+	left := t.build(first, half, bounds)
+	right := t.build(first+half, count-half, bounds)
+	t.nodes[n].Left, t.nodes[n].Right, t.nodes[n].Count = left, right, 0
+	return n
+}
+
+// visit walks the nodes whose bounds pass test, calling leaf for every item
+// in the leaves reached.
+func (t *bvhTree) visit(test func(AABB) bool, leaf func(int)) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &t.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !test(node.Bounds) {
+			continue
+		}
+		if node.Count > 0 {
+			for _, item := range t.items[node.First : node.First+node.Count] {
+				leaf(item)
+			}
+		} else {
+			stack = append(stack, node.Left, node.Right)
+		}
+	}
+}
+
+func (t *bvhTree) bounds() AABB {
+	if len(t.nodes) == 0 {
+		return EmptyAABB()
+	}
+	return t.nodes[0].Bounds
+}
+
+// BVH is a two level bounding volume hierarchy over scene triangles: a tree
+// per group, and a tree over the groups, so a moved group only rebuilds its
+// own tree.
+type BVH struct {
+	Triangles []Triangle
+	Groups    []Group
+	trees     []bvhTree
+	top       bvhTree
+}
+
+// NewBVH indexes triangles, which are shared rather than copied. Triangles not
+// covered by groups are indexed as one more group.
+func NewBVH(triangles []Triangle, groups []Group) *BVH {
+	b := &BVH{Triangles: triangles}
+	covered := make([]bool, len(triangles))
+	for _, g := range groups {
+		for i := g.First; i < g.First+g.Count; i++ {
+			covered[i] = true
+		}
+	}
+	rest := []int{}
+	for i, c := range covered {
+		if !c {
+			rest = append(rest, i)
+		}
+	}
+	b.Groups = groups
+	b.trees = make([]bvhTree, len(groups))
+	for i := range groups {
+		b.rebuildGroup(i)
+	}
+	if len(rest) > 0 {
+		b.trees = append(b.trees, buildTree(rest, b.triangleBounds))
+	}
+	b.rebuildTop()
+	return b
+}
+
+func (b *BVH) triangleBounds(i int) AABB {
+	return b.Triangles[i].Bounds()
+}
+
+func (b *BVH) rebuildGroup(i int) {
+	g := b.Groups[i]
+	items := make([]int, g.Count)
+	for j := range items {
+		items[j] = g.First + j
+	}
+	b.trees[i] = buildTree(items, b.triangleBounds)
+}
+
+func (b *BVH) rebuildTop() {
+	items := []int{}
+	for i := range b.trees {
+		if len(b.trees[i].nodes) > 0 {
+			items = append(items, i)
+		}
+	}
+	b.top = buildTree(items, func(i int) AABB { return b.trees[i].bounds() })
+}
+
+// Update replaces the triangles of group i, such as after its model's
+// transform changed, and rebuilds only that group's tree. Groups are numbered
+// in the order Scene.AddGroup added them, as names need not be unique.
+func (b *BVH) Update(i int, triangles []Triangle) error {
+	if i < 0 || i >= len(b.Groups) {
+		return fmt.Errorf("no group %d", i)
+	}
+	g := b.Groups[i]
+	if len(triangles) != g.Count {
+		return fmt.Errorf("group %s has %d triangles, not %d", g.Name, g.Count, len(triangles))
+	}
+	copy(b.Triangles[g.First:g.First+g.Count], triangles)
+	b.rebuildGroup(i)
+	b.rebuildTop()
+	return nil
+}
+
+func (b *BVH) visit(test func(AABB) bool, leaf func(int)) {
+	b.top.visit(test, func(tree int) {
+		b.trees[tree].visit(test, leaf)
+	})
+}
+
+// TriangleHit is a triangle found by a BVH query.
+type TriangleHit struct {
+	Triangle int
+	Geometry string
+	Normal   glm.Vec4d
+	Distance float64 // along the ray, or from the query shape
+}
+
+func (b *BVH) hit(i int, distance float64) TriangleHit {
+	t := &b.Triangles[i]
+	return TriangleHit{i, t.Geometry, t.Normal(), distance}
+}
+
+// Ray finds the nearest triangle hit by origin + t*dir for minT < t <= maxT.
+func (b *BVH) Ray(origin, dir glm.Vec4d, minT, maxT float64) (TriangleHit, bool) {
+	nearest, nearestT := -1, maxT
+	b.visit(func(box AABB) bool {
+		_, ok := box.rayEntry(origin, dir, nearestT)
+		return ok
+	}, func(i int) {
+		t, ok := b.Triangles[i].Intersect(origin, dir)
+		if ok && t > minT && t <= nearestT {
+			nearest, nearestT = i, t
+		}
+	})
+	if nearest < 0 {
+		return TriangleHit{}, false
+	}
+	return b.hit(nearest, nearestT), true
+}
+
+// Sphere finds the triangles within radius of center.
+func (b *BVH) Sphere(center glm.Vec4d, radius float64) []TriangleHit {
+	box := EmptyAABB().Add(center).Expand(radius)
+	hits := []TriangleHit{}
+	b.visit(box.Overlaps, func(i int) {
+		d := ClosestPointTriangle(center, &b.Triangles[i]).Sub(center).Len()
+		if d < radius {
+			hits = append(hits, b.hit(i, d))
+		}
+	})
+	return hits
+}
+
+// Capsule finds the triangles within the capsule.
+func (b *BVH) Capsule(c Capsule) []TriangleHit {
+	box := EmptyAABB().Add(c.A).Add(c.B).Expand(c.Radius)
+	hits := []TriangleHit{}
+	b.visit(box.Overlaps, func(i int) {
+		s, t := ClosestSegmentTriangle(c.A, c.B, &b.Triangles[i])
+		if d := s.Sub(t).Len(); d < c.Radius {
+			hits = append(hits, b.hit(i, d))
+		}
+	})
+	return hits
+}
+
+// AABB finds the triangles whose bounds overlap box.
+func (b *BVH) AABB(box AABB) []TriangleHit {
+	hits := []TriangleHit{}
+	b.visit(box.Overlaps, func(i int) {
+		if b.Triangles[i].Bounds().Overlaps(box) {
+			hits = append(hits, b.hit(i, 0))
+		}
+	})
+	return hits
+}
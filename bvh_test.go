@@ -0,0 +1,173 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func finite(b AABB) bool {
+	for i := 0; i < 3; i++ {
+		if math.IsInf(b.Min[i], 0) || math.IsInf(b.Max[i], 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUnionEmpty(t *testing.T) {
+	box := EmptyAABB().Add(glm.Vec4d{1, 2, 3, 1}).Add(glm.Vec4d{4, 5, 6, 1})
+	if u := box.Union(EmptyAABB()); u != box {
+		t.Errorf("box union empty is %v, want %v", u, box)
+	}
+	if u := EmptyAABB().Union(box); u != box {
+		t.Errorf("empty union box is %v, want %v", u, box)
+	}
+	if u := EmptyAABB().Union(EmptyAABB()); !u.Empty() {
+		t.Errorf("empty union empty is %v", u)
+	}
+	if box.Empty() || !EmptyAABB().Empty() {
+		t.Error("Empty is wrong")
+	}
+}
+
+func TestBVHEmptyGroup(t *testing.T) {
+	s := &Scene{}
+	s.AddGroup("wall", wall(0, -5, 1))
+	s.AddGroup("nothing", nil)
+	s.Build()
+	if b := s.Index.top.bounds(); !finite(b) {
+		t.Errorf("index bounds %v are infinite", b)
+	}
+	if _, ok := s.Index.Ray(glm.Vec4d{0, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 0, 10); !ok {
+		t.Error("ray missed the wall")
+	}
+	if _, ok := s.Index.Ray(glm.Vec4d{5, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 0, 10); ok {
+		t.Error("ray beside the wall hit it")
+	}
+}
+
+func TestAddGroupAfterBuild(t *testing.T) {
+	s := &Scene{}
+	s.AddGroup("wall", wall(0, -5, 1))
+	s.Build()
+	far := s.AddGroup("far wall", wall(10, -5, 1))
+	tri, dist := s.nearestTriangle(glm.Vec4d{10, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 100)
+	if tri < 0 {
+		t.Fatal("the index missed a group added after it was built")
+	}
+	if math.Abs(dist-5) > 1e-9 {
+		t.Errorf("hit the added group at %v, want 5", dist)
+	}
+	if err := s.Index.Update(far, wall(10, -6, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, dist = s.nearestTriangle(glm.Vec4d{10, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 100); math.Abs(dist-6) > 1e-9 {
+		t.Errorf("hit the moved group at %v, want 6", dist)
+	}
+}
+
+func TestUpdateGroupsSharingAName(t *testing.T) {
+	// every row of the grid is named "row"
+	s := grid(4)
+	down := glm.Vec4d{0, 0, -1, 0}
+	above := func(x float64) float64 {
+		_, dist := s.nearestTriangle(glm.Vec4d{x, 0.5, 10, 1}, down, 100)
+		return dist
+	}
+	before := []float64{above(0.5), above(2.5)}
+	g := s.Groups[2]
+	raised := make([]Triangle, g.Count)
+	for i, tri := range s.Triangles[g.First : g.First+g.Count] {
+		raised[i] = tri.Apply(glm.Translate3Dd(0, 0, 1))
+	}
+	if err := s.Index.Update(2, raised); err != nil {
+		t.Fatal(err)
+	}
+	if d := above(0.5); math.Abs(d-before[0]) > 1e-9 {
+		t.Errorf("the first row moved from %v to %v", before[0], d)
+	}
+	if d := above(2.5); math.Abs(d-(before[1]-1)) > 1e-9 {
+		t.Errorf("the third row is %v below, want %v", d, before[1]-1)
+	}
+	if err := s.Index.Update(len(s.Groups), nil); err == nil {
+		t.Error("no error updating a group past the last")
+	}
+	if err := s.Index.Update(0, raised[:1]); err == nil {
+		t.Error("no error updating a group with the wrong number of triangles")
+	}
+}
+
+// queries compares the index with a linear scan over random rays.
+func TestBVHMatchesLinear(t *testing.T) {
+	s := grid(20)
+	linear := &Scene{Triangles: s.Triangles}
+	rays := randomRays(200)
+	for _, r := range rays {
+		tri, dist := s.nearestTriangle(r[0], r[1], 100)
+		want, wantDist := linear.nearestTriangle(r[0], r[1], 100)
+		if tri != want || math.Abs(dist-wantDist) > 1e-9 {
+			t.Errorf("ray %v: index hit %d at %v, linear scan %d at %v", r, tri, dist, want, wantDist)
+		}
+	}
+	c := Capsule{glm.Vec4d{3, 3, 0.5, 1}, glm.Vec4d{4, 4, 0.5, 1}, 0.6}
+	if got, want := len(s.Contacts(c)), len(linear.Contacts(c)); got != want {
+		t.Errorf("index found %d contacts, linear scan %d", got, want)
+	}
+}
+
+// grid is an n by n height field of bumpy triangles in groups of rows, built.
+func grid(n int) *Scene {
+	r := rand.New(rand.NewSource(1))
+	height := make([][]float64, n+1)
+	for i := range height {
+		height[i] = make([]float64, n+1)
+		for j := range height[i] {
+			height[i][j] = r.Float64()
+		}
+	}
+	s := &Scene{}
+	for i := 0; i < n; i++ {
+		row := []Triangle{}
+		for j := 0; j < n; j++ {
+			p := func(x, y int) glm.Vec4d {
+				return glm.Vec4d{float64(x), float64(y), height[x][y], 1}
+			}
+			row = append(row, Triangle{p(i, j), p(i+1, j), p(i+1, j+1), "grid"}, Triangle{p(i, j), p(i+1, j+1), p(i, j+1), "grid"})
+		}
+		s.AddGroup("row", row)
+	}
+	s.Build()
+	return s
+}
+
+func randomRays(n int) [][2]glm.Vec4d {
+	r := rand.New(rand.NewSource(2))
+	rays := make([][2]glm.Vec4d, n)
+	for i := range rays {
+		origin := glm.Vec4d{r.Float64() * 20, r.Float64() * 20, 5, 1}
+		dir := glm.Vec4d{r.Float64() - 0.5, r.Float64() - 0.5, -1, 0}.Normalize()
+		rays[i] = [2]glm.Vec4d{origin, dir}
+	}
+	return rays
+}
+
+func benchmarkRaycast(b *testing.B, s *Scene) {
+	rays := randomRays(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := rays[i%len(rays)]
+		s.Raycast(r[0], r[1], 100, 0)
+	}
+}
+
+func BenchmarkRaycastLinear(b *testing.B) {
+	s := grid(64)
+	s.Index = nil
+	benchmarkRaycast(b, s)
+}
+
+func BenchmarkRaycastBVH(b *testing.B) {
+	benchmarkRaycast(b, grid(64))
+}
@@ -0,0 +1,97 @@
+// Command portal-sim runs a level without a window, feeding it scripted or
+// recorded input, and prints the player's trajectory as json lines.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/GlenKelley/portal/render"
+	"github.com/GlenKelley/portal/world"
+	"io"
+	"os"
+)
+
+func main() {
+	conf := flag.String("conf", "gameconf.json", "configuration whose constants the world uses")
+	level := flag.String("level", "portal.dae", "level to load, a COLLADA document or a json manifest")
+	input := flag.String("input", "", "input event file to replay")
+	ticks := flag.Int("ticks", 0, "simulation ticks to run, by default as many as the input covers or 600 without input")
+	lenient := flag.Bool("lenient", false, "run levels with errors, leaving out the broken portals")
+	flag.Parse()
+
+	err := run(*conf, *level, *input, *ticks, *lenient, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// defaultTicks is ten seconds at the default time step.
+const defaultTicks = 600
+
+func run(conf, level, input string, ticks int, lenient bool, out io.Writer) error {
+	c, err := loadConstants(conf)
+	if err != nil {
+		return err
+	}
+	if c.TimeStep <= 0 {
+		return fmt.Errorf("time step %v must be positive", c.TimeStep)
+	}
+	w := world.New(c)
+	w.OnError = func(err error) { fmt.Fprintln(os.Stderr, err) }
+	if input != "" {
+		err = w.Input.Replay(input)
+		if err != nil {
+			return err
+		}
+	}
+	if ticks <= 0 {
+		ticks = w.Input.Ticks()
+	}
+	if ticks <= 0 {
+		ticks = defaultTicks
+	}
+	_, problems, err := w.Load(level, render.NewRecorder().NewSurface)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if !lenient {
+		err = problems.Err()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Run(ticks, out)
+}
+
+// loadConstants reads the constants section of a game configuration over
+// the defaults. A missing file leaves the defaults.
+func loadConstants(filename string) (world.Constants, error) {
+	c := world.DefaultConstants
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+	root := struct {
+		Constants json.RawMessage `json:"constants"`
+	}{}
+	err = json.NewDecoder(file).Decode(&root)
+	if err != nil {
+		return c, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(root.Constants) > 0 {
+		err = json.Unmarshal(root.Constants, &c)
+		if err != nil {
+			return c, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden trajectory")
+
+// TestGoldenTrajectory replays scripted input through a level and compares
+// the trajectory with the one recorded in testdata, allowing for rounding.
+func TestGoldenTrajectory(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := run(
+		filepath.Join("testdata", "no-such-conf.json"),
+		filepath.Join("testdata", "level.json"),
+		filepath.Join("testdata", "input.json"),
+		240,
+		false,
+		out,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "trajectory.jsonl")
+	if *update {
+		err = ioutil.WriteFile(golden, out.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := records(t, out.Bytes())
+	wantRecords := records(t, want)
+	if len(got) != len(wantRecords) {
+		t.Fatalf("got %d records, want %d", len(got), len(wantRecords))
+	}
+	crossings := 0
+	for i := range got {
+		if got[i]["type"] == "crossing" {
+			crossings++
+		}
+		if !same(got[i], wantRecords[i]) {
+			t.Fatalf("record %d is\n%v\nwant\n%v", i, got[i], wantRecords[i])
+		}
+	}
+	if crossings == 0 {
+		t.Error("the trajectory never crosses a portal")
+	}
+}
+
+func records(t *testing.T, data []byte) []map[string]interface{} {
+	rs := []map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		r := map[string]interface{}{}
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			t.Fatalf("line %d: %v", len(rs)+1, err)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// same compares decoded json, numbers to within 1e-6.
+func same(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		f, ok := b.(float64)
+		return ok && math.Abs(a-f) <= 1e-6
+	case []interface{}:
+		l, ok := b.([]interface{})
+		if !ok || len(a) != len(l) {
+			return false
+		}
+		for i := range a {
+			if !same(a[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		m, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(m) {
+			return false
+		}
+		for k, v := range a {
+			if !same(v, m[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
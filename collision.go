@@ -0,0 +1,255 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Capsule is the set of points within Radius of the segment A-B.
+type Capsule struct {
+	A      glm.Vec4d
+	B      glm.Vec4d
+	Radius float64
+}
+
+func (c Capsule) Translate(d glm.Vec4d) Capsule {
+	return Capsule{c.A.Add(d), c.B.Add(d), c.Radius}
+}
+
+// Contact is a triangle penetrating a capsule.
+type Contact struct {
+	Point    glm.Vec4d // on the triangle
+	Normal   glm.Vec4d // the direction pushing the capsule out
+	Depth    float64
+	Triangle int
+}
+
+// collisionIterations bounds how many times a step is pushed out and slid
+// along the surfaces it touches.
+const collisionIterations = 8
+
+// Contacts lists the triangles penetrating the capsule, leaving out contacts
+// inside a portal's opening so that portals on walls can be walked through.
+func (s *Scene) Contacts(c Capsule) []Contact {
+	contacts := []Contact{}
+	if s.Index != nil {
+		for _, hit := range s.Index.Capsule(c) {
+			if contact, ok := s.contact(c, hit.Triangle); ok {
+				contacts = append(contacts, contact)
+			}
+		}
+		return contacts
+	}
+	for i := range s.Triangles {
+		if contact, ok := s.contact(c, i); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+func (s *Scene) contact(c Capsule, i int) (Contact, bool) {
+	t := &s.Triangles[i]
+	onSegment, onTriangle := ClosestSegmentTriangle(c.A, c.B, t)
+	d := onSegment.Sub(onTriangle)
+	dist := d.Len()
+	if dist >= c.Radius || s.InOpening(onTriangle) {
+		return Contact{}, false
+	}
+	normal := t.Normal()
+	if dist > 1e-9 {
+		normal = d.Mul(1 / dist)
+	} else if normal.Dot(c.A.Add(c.B).Mul(0.5).Sub(onTriangle)) < 0 {
+		normal = normal.Mul(-1)
+	}
+	return Contact{onTriangle, normal, c.Radius - dist, i}, true
+}
+
+// openingInset shrinks a portal's opening, in local horizon units, so that
+// surfaces meeting the horizon's edge, like a floor under a doorway, still
+// collide.
+const openingInset = 1e-6
+
+// InOpening reports whether a world space point lies strictly within the
+// volume a portal's horizon sweeps out along its normal.
+func (s *Scene) InOpening(p glm.Vec4d) bool {
+	grow := 1 / (1 - openingInset)
+	for i := range s.Portals {
+		portal := &s.Portals[i]
+		local := portal.Portalview.Mul4x1(p)
+		if math.Abs(local[2]) < 1 && portal.EventHorizon.Contains(local[0]*grow, local[1]*grow) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sweep moves the capsule by move in steps no longer than its radius, pushing
+// it out of the scene and sliding it along the surfaces it touches. It returns
+// the displacement actually made and the normals of the surfaces touched.
+func (s *Scene) Sweep(c Capsule, move glm.Vec4d) (glm.Vec4d, []glm.Vec4d) {
+	normals := []glm.Vec4d{}
+	moved := glm.Vec4d{}
+	steps := int(math.Ceil(2 * move.Len() / c.Radius))
+	if steps < 1 {
+		steps = 1
+	}
+	step := move.Mul(1 / float64(steps))
+	for i := 0; i < steps; i++ {
+		c = c.Translate(step)
+		moved = moved.Add(step)
+		// each push moves the capsule, so the contacts are found again after
+		// resolving the deepest one
+		for j := 0; j < collisionIterations; j++ {
+			contacts := s.Contacts(c)
+			if len(contacts) == 0 {
+				break
+			}
+			contact := deepest(contacts)
+			push := contact.Normal.Mul(contact.Depth)
+			c = c.Translate(push)
+			moved = moved.Add(push)
+			step = slide(step, contact.Normal)
+			normals = append(normals, contact.Normal)
+		}
+	}
+	return moved, normals
+}
+
+func deepest(contacts []Contact) Contact {
+	d := contacts[0]
+	for _, c := range contacts[1:] {
+		if c.Depth > d.Depth {
+			d = c
+		}
+	}
+	return d
+}
+
+// Touching lists the normals of the surfaces within skin of the capsule. Sweep
+// leaves the capsule just clear of what it was pushed out of, so resting
+// contact is found with a small skin.
+func (s *Scene) Touching(c Capsule, skin float64) []glm.Vec4d {
+	normals := []glm.Vec4d{}
+	for _, contact := range s.Contacts(Capsule{c.A, c.B, c.Radius + skin}) {
+		normals = append(normals, contact.Normal)
+	}
+	return normals
+}
+
+// slide removes the part of v heading into a surface with the given normal.
+func slide(v, normal glm.Vec4d) glm.Vec4d {
+	into := v.Dot(normal)
+	if into >= 0 {
+		return v
+	}
+	return v.Sub(normal.Mul(into))
+}
+
+// Slide removes the part of v heading into any of the surfaces.
+func Slide(v glm.Vec4d, normals []glm.Vec4d) glm.Vec4d {
+	for _, n := range normals {
+		v = slide(v, n)
+	}
+	return v
+}
+
+// ClosestPointTriangle is the point of t nearest to p.
+func ClosestPointTriangle(p glm.Vec4d, t *Triangle) glm.Vec4d {
+	a, b, c := t.A, t.B, t.C
+	ab := b.Sub(a)
+	ac := c.Sub(a)
+	ap := p.Sub(a)
+	d1 := ab.Dot(ap)
+	d2 := ac.Dot(ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+	bp := p.Sub(b)
+	d3 := ab.Dot(bp)
+	d4 := ac.Dot(bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return a.Add(ab.Mul(d1 / (d1 - d3)))
+	}
+	cp := p.Sub(c)
+	d5 := ab.Dot(cp)
+	d6 := ac.Dot(cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return a.Add(ac.Mul(d2 / (d2 - d6)))
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		return b.Add(c.Sub(b).Mul((d4 - d3) / ((d4 - d3) + (d5 - d6))))
+	}
+	denom := 1 / (va + vb + vc)
+	return a.Add(ab.Mul(vb * denom)).Add(ac.Mul(vc * denom))
+}
+
+// ClosestSegments returns the nearest pair of points on the segments p0-p1
+// and q0-q1.
+func ClosestSegments(p0, p1, q0, q1 glm.Vec4d) (glm.Vec4d, glm.Vec4d) {
+	d1 := p1.Sub(p0)
+	d2 := q1.Sub(q0)
+	r := p0.Sub(q0)
+	a := d1.Dot(d1)
+	e := d2.Dot(d2)
+	f := d2.Dot(r)
+	clamp := func(x float64) float64 { return math.Max(0, math.Min(1, x)) }
+	var s, t float64
+	switch {
+	case a <= 1e-12 && e <= 1e-12:
+		return p0, q0
+	case a <= 1e-12:
+		t = clamp(f / e)
+	default:
+		c := d1.Dot(r)
+		if e <= 1e-12 {
+			s = clamp(-c / a)
+		} else {
+			b := d1.Dot(d2)
+			denom := a*e - b*b
+			if denom > 1e-12 {
+				s = clamp((b*f - c*e) / denom)
+			}
+			t = (b*s + f) / e
+			if t < 0 {
+				t = 0
+				s = clamp(-c / a)
+			} else if t > 1 {
+				t = 1
+				s = clamp((b - c) / a)
+			}
+		}
+	}
+	return p0.Add(d1.Mul(s)), q0.Add(d2.Mul(t))
+}
+
+// ClosestSegmentTriangle returns the nearest pair of points on the segment
+// p0-p1 and the triangle t.
+func ClosestSegmentTriangle(p0, p1 glm.Vec4d, t *Triangle) (glm.Vec4d, glm.Vec4d) {
+	dir := p1.Sub(p0)
+	if s, ok := t.Intersect(p0, dir); ok && s >= 0 && s <= 1 {
+		p := p0.Add(dir.Mul(s))
+		return p, p
+	}
+	bestS, bestT := p0, ClosestPointTriangle(p0, t)
+	best := bestS.Sub(bestT).Len()
+	consider := func(s, t glm.Vec4d) {
+		if d := s.Sub(t).Len(); d < best {
+			bestS, bestT, best = s, t, d
+		}
+	}
+	consider(p1, ClosestPointTriangle(p1, t))
+	consider(ClosestSegments(p0, p1, t.A, t.B))
+	consider(ClosestSegments(p0, p1, t.B, t.C))
+	consider(ClosestSegments(p0, p1, t.C, t.A))
+	return bestS, bestT
+}
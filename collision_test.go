@@ -0,0 +1,139 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+// floor is a square of two triangles at height y, facing up.
+func floor(y, half float64) []Triangle {
+	a := glm.Vec4d{-half, y, -half, 1}
+	b := glm.Vec4d{-half, y, half, 1}
+	c := glm.Vec4d{half, y, half, 1}
+	d := glm.Vec4d{half, y, -half, 1}
+	return []Triangle{{a, b, c, "floor"}, {a, c, d, "floor"}}
+}
+
+func standing(x, feet, radius float64) Capsule {
+	return Capsule{glm.Vec4d{x, feet + radius, 0, 1}, glm.Vec4d{x, feet + 1, 0, 1}, radius}
+}
+
+func TestSweepSharedEdge(t *testing.T) {
+	// the capsule lands on the diagonal both floor triangles share, and must
+	// be pushed out once rather than once for each triangle
+	s := &Scene{Triangles: floor(0, 5)}
+	c := standing(0, 0.1, 0.25)
+	moved, normals := s.Sweep(c, glm.Vec4d{0, -0.2, 0, 0})
+	if len(normals) == 0 {
+		t.Fatal("no contact with the floor")
+	}
+	if math.Abs(moved[1]+0.1) > 1e-9 {
+		t.Errorf("moved %v, want to come to rest on the floor 0.1 down", moved)
+	}
+}
+
+func TestSweepCorner(t *testing.T) {
+	s := &Scene{Triangles: floor(0, 5)}
+	// a wall at x=1 facing -x
+	a := glm.Vec4d{1, 0, -5, 1}
+	b := glm.Vec4d{1, 5, -5, 1}
+	c := glm.Vec4d{1, 5, 5, 1}
+	d := glm.Vec4d{1, 0, 5, 1}
+	s.Triangles = append(s.Triangles, Triangle{a, b, c, "wall"}, Triangle{a, c, d, "wall"})
+	capsule := standing(0, 0, 0.25)
+	moved, _ := s.Sweep(capsule, glm.Vec4d{2, -1, 0, 0})
+	end := capsule.Translate(moved)
+	if contacts := s.Contacts(end); len(contacts) != 0 {
+		t.Errorf("capsule left penetrating %d surfaces at %v", len(contacts), end)
+	}
+	if math.Abs(end.A[0]-0.75) > 1e-6 || math.Abs(end.A[1]-0.25) > 1e-6 {
+		t.Errorf("capsule came to rest at %v, want in the corner at (0.75, 0.25)", end.A)
+	}
+}
+
+func TestSweepSlides(t *testing.T) {
+	s := &Scene{Triangles: floor(0, 5)}
+	c := standing(0, 0, 0.25)
+	moved, _ := s.Sweep(c, glm.Vec4d{1, -1, 0, 0})
+	if math.Abs(moved[0]-1) > 1e-9 || math.Abs(moved[1]) > 1e-9 {
+		t.Errorf("moved %v, want to slide 1 along the floor", moved)
+	}
+}
+
+func TestTouching(t *testing.T) {
+	s := &Scene{Triangles: floor(0, 5)}
+	c := standing(0, 0, 0.25)
+	if len(s.Contacts(c)) != 0 {
+		t.Error("a capsule resting on the floor penetrates it")
+	}
+	normals := s.Touching(c, 0.01)
+	if len(normals) == 0 {
+		t.Fatal("a capsule resting on the floor does not touch it")
+	}
+	for _, n := range normals {
+		if !approxVec(n, glm.Vec4d{0, 1, 0, 0}) {
+			t.Errorf("floor normal %v, want up", n)
+		}
+	}
+	if len(s.Touching(standing(0, 0.1, 0.25), 0.01)) != 0 {
+		t.Error("a capsule 0.1 above the floor touches it")
+	}
+}
+
+// doorway is a wall at z=-5, facing +z, with a portal in it at (0, 0) leading
+// to a horizon far away. The opening spans x and y in [-1, 1].
+func doorway(t *testing.T) *Scene {
+	n := NewNetwork()
+	n.Horizons[1] = facing(t, 0, -5, glm.Vec4d{0, 0, -1, 0})
+	n.Horizons[2] = facing(t, 100, -5, glm.Vec4d{0, 0, -1, 0})
+	n.Link(1, 2)
+	portals, _ := n.Portals()
+	return &Scene{Portals: portals, Triangles: wall(0, -5, 5)}
+}
+
+// upright is a capsule centered on (x, 0, z), short enough to fit through
+// the doorway.
+func upright(x, z float64) Capsule {
+	return Capsule{glm.Vec4d{x, -0.4, z, 1}, glm.Vec4d{x, 0.4, z, 1}, 0.25}
+}
+
+func TestSweepThroughOpening(t *testing.T) {
+	s := doorway(t)
+	move := glm.Vec4d{0, 0, -2, 0}
+	moved, normals := s.Sweep(upright(0, -4), move)
+	if !approxVec(moved, move) || len(normals) != 0 {
+		t.Errorf("moved %v touching %v, want to pass through the opening", moved, normals)
+	}
+}
+
+func TestSweepBesideOpening(t *testing.T) {
+	s := doorway(t)
+	c := upright(3, -4)
+	moved, normals := s.Sweep(c, glm.Vec4d{0, 0, -2, 0})
+	if len(normals) == 0 {
+		t.Fatal("no contact with the wall beside the portal")
+	}
+	end := c.Translate(moved)
+	if math.Abs(end.A[2]-(-5+c.Radius)) > 1e-6 {
+		t.Errorf("capsule stopped at z=%v, want against the wall at %v", end.A[2], -5+c.Radius)
+	}
+}
+
+func TestSweepFloorAtOpeningEdge(t *testing.T) {
+	// a floor level with the bottom of the opening still holds the capsule
+	// up in front of the portal
+	s := doorway(t)
+	s.Triangles = append(s.Triangles, floor(-1, 5)...)
+	c := Capsule{glm.Vec4d{0, -0.75, -4.8, 1}, glm.Vec4d{0, 0.25, -4.8, 1}, 0.25}
+	if !s.InOpening(glm.Vec4d{0, -0.99, -4.99, 1}) {
+		t.Fatal("a point inside the opening is outside it")
+	}
+	if s.InOpening(glm.Vec4d{0, -1, -4.8, 1}) {
+		t.Error("the floor at the opening's edge is inside it")
+	}
+	moved, normals := s.Sweep(c, glm.Vec4d{0, -0.5, 0, 0})
+	if len(normals) == 0 || math.Abs(moved[1]) > 1e-6 {
+		t.Errorf("moved %v, want to rest on the floor", moved)
+	}
+}
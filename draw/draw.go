@@ -0,0 +1,278 @@
+// Package draw renders a world's level and the views through its portals on
+// any render.Renderer. Each view is confined to the portals it is seen
+// through by the stencil buffer, one stencil level per level of recursion.
+package draw
+
+import (
+	"fmt"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"github.com/GlenKelley/portal/render"
+	"github.com/GlenKelley/portal/world"
+	glm "github.com/Jragonmiris/mathgl"
+)
+
+// The programs a Painter draws with, by their name in the Renderer.
+const (
+	ProgramScene    = "scene"
+	ProgramFill     = "fill"
+	ProgramFallback = "fallback"
+)
+
+// Fallbacks are what is drawn inside portals at the last level of recursion.
+const (
+	FallbackColor = "color"
+	FallbackFrame = "frame"
+	FallbackBlur  = "blur"
+)
+
+// FallbackBlurSpread is the texels between the blur taps.
+const FallbackBlurSpread = 3
+
+type Options struct {
+	RecursionDepth int
+	Fallback       string
+	FallbackColor  gtk.Color
+	Far            float64 // depth of the fills, which the fill shader clamps to the far plane
+	Debug          bool    // outline the portals and glow the views through them
+}
+
+// Painter draws a world. Portals holds one geometry for each of the world's
+// Level.Portals, in order, and is rebuilt when they change.
+type Painter struct {
+	Renderer render.Renderer
+	World    *world.World
+	Scene    *gtk.Model
+	Portals  *gtk.Model
+	Fill     *gtk.Geometry // a quad over the whole screen
+	Options
+
+	// the frame being drawn
+	projection    glm.Mat4d
+	width, height int
+}
+
+// New makes a Painter for the level model scene, which the world was loaded
+// with.
+func New(r render.Renderer, w *world.World, scene *gtk.Model, o Options) *Painter {
+	p := &Painter{Renderer: r, World: w, Scene: scene, Options: o}
+	p.Fill = p.NewPlane("plane1", portal.Quad{
+		glm.Vec4d{0, 0, 0, 1},
+		glm.Vec4d{0, 0, 1, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{1, 1, 1, 0},
+		portal.Rectangle{},
+	})
+	p.RebuildPortals()
+	return p
+}
+
+// RebuildPortals makes the portal geometry again after the world's portals
+// change.
+func (p *Painter) RebuildPortals() {
+	w := p.World
+	p.Portals = gtk.EmptyModel("portals")
+	for i, q := range w.Level.Portals {
+		p.Portals.AddGeometry(p.NewPlane(fmt.Sprintf("portal_%d", w.PortalIds[i]), q.EventHorizon))
+	}
+}
+
+func (p *Painter) NewPlane(name string, q portal.Quad) *gtk.Geometry {
+	vs, ns := q.Mesh()
+	return p.Renderer.NewGeometry(name, vs, ns, q.Elements())
+}
+
+// CheckStencilBits checks that a stencil buffer of the given bits holds the
+// levels a recursion depth needs.
+func CheckStencilBits(depth, bits int) error {
+	if depth < 0 {
+		return fmt.Errorf("portal recursion depth %d is negative", depth)
+	}
+	// levels 0 to depth hold the views, depth+1 marks the fallback
+	levels := depth + 2
+	if bits < 31 && levels > 1<<uint(bits) {
+		return fmt.Errorf("portal recursion depth %d needs %d stencil levels but the framebuffer has %d stencil bits (%d levels)", depth, levels, bits, 1<<uint(bits))
+	}
+	return nil
+}
+
+func CheckFallback(fallback string) error {
+	switch fallback {
+	case FallbackColor, FallbackFrame, FallbackBlur:
+		return nil
+	}
+	return fmt.Errorf("unknown portal fallback %q", fallback)
+}
+
+// Check checks the options against the renderer.
+func (p *Painter) Check() error {
+	err := CheckFallback(p.Fallback)
+	if err != nil {
+		return err
+	}
+	return CheckStencilBits(p.RecursionDepth, p.Renderer.StencilBits())
+}
+
+// Draw draws a frame of the given size seen through projection, and keeps it
+// for the next frame's fallback.
+func (p *Painter) Draw(projection glm.Mat4d, width, height int) {
+	p.projection, p.width, p.height = projection, width, height
+	g := p.Renderer
+	g.Clear(gtk.SoftBlack)
+
+	g.UseProgram(ProgramFill)
+	g.UniformColor("color", gtk.SkyBlue)
+	g.UniformFloat("depth", p.Far)
+	g.UseProgram(ProgramScene)
+	g.UniformFloat("elapsed", p.World.Elapsed())
+	g.UniformFloat("glow", 0)
+	g.UniformMatrix("projection", projection)
+	g.UniformMatrix("cameraview", p.World.Cameraview())
+	g.UniformMatrix("inception", p.World.Inception)
+	mv := glm.Ident4d()
+	g.UniformMatrix("worldview", mv)
+
+	g.Scissor(portal.FullScreen)
+	p.DrawPortalScene(mv, projection, 0, p.RecursionDepth, portal.FullScreen)
+	g.NoScissor()
+	if p.Fallback != FallbackColor {
+		g.CaptureFrame()
+	}
+}
+
+// DrawPortalScene draws the scene where the stencil holds stencilLevel, then
+// the view through each portal visible within view at the next level, down
+// to depth more levels. Portals are culled and clipped by the frame's
+// projection, set by Draw.
+func (p *Painter) DrawPortalScene(mv, projection glm.Mat4d, stencilLevel int, depth int, view portal.Rect) {
+	g := p.Renderer
+	s := g.Stencil()
+	if depth == 0 {
+		// the stencil is left marking by its parent's portal fill
+		s.Enable().Mask(stencilLevel).Draw().Keep()
+		p.DrawScene(mv)
+		p.DrawPortalFallback(mv, stencilLevel)
+		s.Disable()
+		return
+	}
+	s.Enable().Mask(stencilLevel).NoDraw()
+	p.DrawScene(mv)
+	s.DepthLE().Increment()
+	g.Cull(true)
+	p.DrawModel(mv, p.Portals, false)
+	if p.Debug {
+		p.DrawModel(mv, p.Portals, true)
+	}
+	g.Cull(false)
+	s.Draw().Keep()
+
+	p.DrawScene(mv)
+
+	if p.Debug {
+		g.UniformFloat("glow", 1)
+		s.Mask(stencilLevel + 1)
+		p.DrawModel(mv, p.Portals, true)
+		g.UniformFloat("glow", 0)
+	}
+
+	// the scene is drawn at stencilLevel and its portals marked at the next
+	// level, which is cleared again before each portal is drawn into
+	p.StepDown(stencilLevel + 1)
+	g.UseProgram(ProgramScene)
+	s.Enable().Depth().DepthLE().Mask(stencilLevel)
+
+	cameraview := p.World.Cameraview().Mul4(mv)
+	for i, port := range p.World.Level.Portals {
+		rect, visible := port.Visible(p.projection, cameraview, view)
+		if !visible {
+			continue
+		}
+		g.Scissor(rect)
+		s.NoDraw().Increment()
+		g.UniformMatrix("worldview", mv)
+		g.Cull(true)
+		g.DrawGeometry(p.Portals.Geometry[i], false)
+		g.Cull(false)
+		s.Draw().Keep()
+
+		// the depth behind the portal is cleared to the far plane
+		g.UseProgram(ProgramFill)
+		s.NoDraw().DepthAlways().Mask(stencilLevel + 1)
+		g.DrawGeometry(p.Fill, false)
+		g.UseProgram(ProgramScene)
+		s.Enable().Depth().DepthLE().Mask(stencilLevel)
+
+		oblique := port.Projection(p.projection, cameraview)
+		g.UniformMatrix("projection", oblique)
+		p.DrawPortalScene(mv.Mul4(port.Transform), oblique, stencilLevel+1, depth-1, rect)
+
+		p.StepDown(stencilLevel + 1)
+		g.Scissor(view)
+		g.UseProgram(ProgramScene)
+		g.UniformMatrix("projection", projection)
+		s.Enable().Depth().DepthLE().Mask(stencilLevel)
+	}
+	s.Disable()
+}
+
+// StepDown returns the pixels at stencilLevel to the level below.
+func (p *Painter) StepDown(stencilLevel int) {
+	g := p.Renderer
+	s := g.Stencil()
+	g.UseProgram(ProgramFill)
+	s.Enable().NoDepth().NoDepthMask().NoDraw().Mask(stencilLevel).Decrement()
+	g.DrawGeometry(p.Fill, false)
+	s.Disable()
+}
+
+// DrawPortalFallback draws the fallback over the portals visible at
+// stencilLevel.
+func (p *Painter) DrawPortalFallback(mv glm.Mat4d, stencilLevel int) {
+	g := p.Renderer
+	s := g.Stencil()
+	g.UseProgram(ProgramScene)
+	s.Enable().Depth().DepthLE().Mask(stencilLevel).NoDraw().Increment()
+	g.Cull(true)
+	p.DrawModel(mv, p.Portals, false)
+	g.Cull(false)
+	s.Draw().Keep().DepthAlways().Mask(stencilLevel + 1)
+
+	switch p.Fallback {
+	case FallbackFrame, FallbackBlur:
+		blur := 0.0
+		if p.Fallback == FallbackBlur {
+			blur = FallbackBlurSpread
+		}
+		g.UseProgram(ProgramFallback)
+		g.UniformFrame("frame")
+		g.UniformVec2("texel", glm.Vec2d{1 / float64(p.width), 1 / float64(p.height)})
+		g.UniformFloat("blur", blur)
+		g.UniformFloat("depth", p.Far)
+		g.DrawGeometry(p.Fill, false)
+	default:
+		g.UseProgram(ProgramFill)
+		g.UniformColor("color", p.FallbackColor)
+		g.DrawGeometry(p.Fill, false)
+	}
+	p.StepDown(stencilLevel + 1)
+	g.UseProgram(ProgramScene)
+}
+
+// DrawScene draws the level and the bodies in it.
+func (p *Painter) DrawScene(mv glm.Mat4d) {
+	p.DrawModel(mv, p.Scene, false)
+	for _, instance := range p.World.Instances() {
+		p.DrawModel(mv.Mul4(instance.View), instance.Model, false)
+	}
+}
+
+func (p *Painter) DrawModel(mv glm.Mat4d, model *gtk.Model, lines bool) {
+	mv2 := mv.Mul4(model.Transform)
+	p.Renderer.UniformMatrix("worldview", mv2)
+	for _, geo := range model.Geometry {
+		p.Renderer.DrawGeometry(geo, lines)
+	}
+	for _, child := range model.Children {
+		p.DrawModel(mv2, child, lines)
+	}
+}
@@ -0,0 +1,298 @@
+package draw
+
+import (
+	"fmt"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"github.com/GlenKelley/portal/render"
+	"github.com/GlenKelley/portal/world"
+	glm "github.com/Jragonmiris/mathgl"
+	"strings"
+	"testing"
+)
+
+// ahead is a portal at x on the wall at z=-5, which the player at the origin
+// looks into.
+func ahead(x float64) portal.Quad {
+	q := world.UnitQuad
+	q.Center = glm.Vec4d{x, 1, -5, 1}
+	q.Normal = glm.Vec4d{0, 0, -1, 0}
+	q.PlaneV = glm.Vec4d{-1, 0, 0, 0}
+	return q
+}
+
+// behind is a portal on the wall at z=5, behind the player.
+func behind() portal.Quad {
+	q := world.UnitQuad
+	q.Center = glm.Vec4d{0, 1, 5, 1}
+	return q
+}
+
+// painter draws a world with the player at the origin looking down -Z, and
+// the given portals linked in pairs by index.
+func painter(r render.Renderer, o Options, horizons []portal.Quad, links [][2]int) *Painter {
+	w := world.New(world.DefaultConstants)
+	w.Player = world.NewPlayer(glm.Vec4d{0, 1, 0, 1})
+	for i, q := range horizons {
+		w.Network.Horizons[i+1] = q
+	}
+	for _, l := range links {
+		w.Network.Link(l[0]+1, l[1]+1)
+	}
+	w.RebuildPortals()
+	scene := gtk.EmptyModel("level")
+	scene.AddGeometry(r.NewGeometry("level", nil, nil, map[gl.Enum][]int16{}))
+	return New(r, w, scene, o)
+}
+
+func projection() glm.Mat4d {
+	return glm.Perspectived(70, 4.0/3, 0.001, 100)
+}
+
+// trace lists each draw as the stencil op, the level the stencil test is
+// against and the geometry, marking the draws which write no color.
+func trace(r *render.Recorder) []string {
+	op, level, color := "keep", 0, true
+	lines := []string{}
+	for _, c := range r.Commands {
+		switch c.Op {
+		case "stencil.increment", "stencil.decrement", "stencil.keep":
+			op = strings.TrimPrefix(c.Op, "stencil.")
+		case "stencil.mask":
+			level = c.Value.(int)
+		case "stencil.draw":
+			color = true
+		case "stencil.nodraw":
+			color = false
+		case "draw":
+			s := fmt.Sprintf("%s %d %s", op, level, c.Name)
+			if !color {
+				s += " (stencil only)"
+			}
+			lines = append(lines, s)
+		}
+	}
+	return lines
+}
+
+// draw draws a frame with a Recorder and returns its trace.
+func draw(horizons []portal.Quad, links [][2]int, o Options) []string {
+	r := render.NewRecorder()
+	p := painter(r, o, horizons, links)
+	r.Reset()
+	p.Draw(projection(), 640, 480)
+	return trace(r)
+}
+
+func expectTrace(t *testing.T, got, want []string) {
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("drew\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+var colorFallback = Options{RecursionDepth: 1, Fallback: FallbackColor, Far: 100}
+
+func TestDrawNoPortals(t *testing.T) {
+	expectTrace(t, draw(nil, nil, colorFallback), []string{
+		"keep 0 level (stencil only)",
+		"keep 0 level",
+		"decrement 1 plane1 (stencil only)",
+	})
+}
+
+func TestDrawOnePortal(t *testing.T) {
+	// the exit is out of sight, beside the player
+	side := world.UnitQuad
+	side.Center = glm.Vec4d{10, 1, 0, 1}
+	side.Normal = glm.Vec4d{1, 0, 0, 0}
+	side.PlaneV = glm.Vec4d{0, 0, -1, 0}
+	expectTrace(t, draw([]portal.Quad{ahead(0), side}, [][2]int{{0, 1}}, colorFallback), []string{
+		"keep 0 level (stencil only)",
+		"increment 0 portal_1 (stencil only)",
+		"increment 0 portal_2 (stencil only)",
+		"keep 0 level",
+		"decrement 1 plane1 (stencil only)",
+
+		"increment 0 portal_1 (stencil only)",
+		"keep 1 plane1 (stencil only)",
+		"keep 1 level",
+		"increment 1 portal_1 (stencil only)",
+		"increment 1 portal_2 (stencil only)",
+		"keep 2 plane1",
+		"decrement 2 plane1 (stencil only)",
+		"decrement 1 plane1 (stencil only)",
+	})
+}
+
+func TestDrawTwoPortals(t *testing.T) {
+	view := func(id string) []string {
+		return []string{
+			"increment 0 portal_" + id + " (stencil only)",
+			"keep 1 plane1 (stencil only)",
+			"keep 1 level",
+			"increment 1 portal_1 (stencil only)",
+			"increment 1 portal_2 (stencil only)",
+			"keep 2 plane1",
+			"decrement 2 plane1 (stencil only)",
+			"decrement 1 plane1 (stencil only)",
+		}
+	}
+	want := []string{
+		"keep 0 level (stencil only)",
+		"increment 0 portal_1 (stencil only)",
+		"increment 0 portal_2 (stencil only)",
+		"keep 0 level",
+		"decrement 1 plane1 (stencil only)",
+	}
+	want = append(want, view("1")...)
+	want = append(want, view("2")...)
+	expectTrace(t, draw([]portal.Quad{ahead(-1.5), ahead(1.5)}, [][2]int{{0, 1}}, colorFallback), want)
+}
+
+// In a corridor of portals facing each other each view holds the next, down
+// to the recursion depth, where the fallback fills the portal at the level
+// past it. The deepest recursion an 8 bit stencil allows is drawn too.
+func TestDrawNestedPortals(t *testing.T) {
+	for _, depth := range []int{0, 1, 2, 3, 6, 1<<8 - 2} {
+		if err := CheckStencilBits(depth, 8); err != nil {
+			t.Fatal(err)
+		}
+		o := colorFallback
+		o.RecursionDepth = depth
+		got := draw([]portal.Quad{ahead(0), behind()}, [][2]int{{0, 1}}, o)
+
+		deepest := 0
+		drawn := map[int]bool{}
+		fills := []int{}
+		for _, line := range got {
+			var op, name, only string
+			var level int
+			fmt.Sscan(line, &op, &level, &name, &only)
+			if level > deepest {
+				deepest = level
+			}
+			if name == "level" && only == "" {
+				drawn[level] = true
+			}
+			if name == "plane1" && op == "keep" && only == "" {
+				fills = append(fills, level)
+			}
+		}
+		if deepest != depth+1 {
+			t.Errorf("depth %d: stencil reached level %d, want %d", depth, deepest, depth+1)
+		}
+		for level := 0; level <= depth; level++ {
+			if !drawn[level] {
+				t.Errorf("depth %d: the scene is not drawn at level %d", depth, level)
+			}
+		}
+		if len(fills) != 1 || fills[0] != depth+1 {
+			t.Errorf("depth %d: fallback filled at levels %v, want only %d", depth, fills, depth+1)
+		}
+		if n := len(got); got[n-1] != "decrement 1 plane1 (stencil only)" {
+			t.Errorf("depth %d: the frame ends with %q, not a step down to level 0", depth, got[n-1])
+		}
+	}
+}
+
+func TestCheckStencilBits(t *testing.T) {
+	for _, c := range []struct {
+		depth, bits int
+		ok          bool
+	}{
+		{0, 1, true},
+		{1, 1, false},
+		{1, 2, true},
+		{2, 2, true},
+		{3, 2, false},
+		{254, 8, true},
+		{255, 8, false},
+		{1000, 31, true},
+		{-1, 8, false},
+	} {
+		err := CheckStencilBits(c.depth, c.bits)
+		if (err == nil) != c.ok {
+			t.Errorf("depth %d with %d stencil bits: error %v, want ok %v", c.depth, c.bits, err, c.ok)
+		}
+	}
+	r := render.NewRecorder()
+	r.Bits = 2
+	p := painter(r, Options{RecursionDepth: 3, Fallback: FallbackColor}, nil, nil)
+	if err := p.Check(); err == nil {
+		t.Error("a recursion depth of 3 passes the check with a 2 bit stencil")
+	}
+	p.Fallback = "mirror"
+	p.RecursionDepth = 1
+	if err := p.Check(); err == nil {
+		t.Error("an unknown fallback passes the check")
+	}
+}
+
+// fills lists the draws which write color through the stencil's fill, each
+// with its program and the uniforms set since that program was chosen.
+func fills(r *render.Recorder) [][]string {
+	found := [][]string{}
+	color := true
+	set := []string{}
+	for _, c := range r.Commands {
+		switch c.Op {
+		case "stencil.draw":
+			color = true
+		case "stencil.nodraw":
+			color = false
+		case "program":
+			set = []string{c.String()}
+		case "uniform", "uniform.frame":
+			set = append(set, c.String())
+		case "draw":
+			if color && c.Name == "plane1" {
+				found = append(found, append(set, c.String()))
+			}
+		}
+	}
+	return found
+}
+
+// Every fallback fills the portals at the recursion limit once, and the frame
+// and blur fallbacks keep the frame for the next one.
+func TestDrawFallbacks(t *testing.T) {
+	far := "uniform depth 100"
+	for _, c := range []struct {
+		fallback string
+		fill     []string
+		capture  bool
+	}{
+		{FallbackColor, []string{"program fill", fmt.Sprint("uniform color ", gtk.Color{0.5, 0.25, 0, 1}), "draw plane1"}, false},
+		{FallbackFrame, []string{"program fallback", "uniform.frame frame", "uniform texel [0.0015625 0.0020833333333333333]", "uniform blur 0", far, "draw plane1"}, true},
+		{FallbackBlur, []string{"program fallback", "uniform.frame frame", "uniform texel [0.0015625 0.0020833333333333333]", "uniform blur 3", far, "draw plane1"}, true},
+	} {
+		for _, depth := range []int{0, 2} {
+			o := Options{RecursionDepth: depth, Fallback: c.fallback, FallbackColor: gtk.Color{0.5, 0.25, 0, 1}, Far: 100}
+			r := render.NewRecorder()
+			p := painter(r, o, []portal.Quad{ahead(0), behind()}, [][2]int{{0, 1}})
+			r.Reset()
+			p.Draw(projection(), 640, 480)
+			got := fills(r)
+			if len(got) != 1 || strings.Join(got[0], "\n") != strings.Join(c.fill, "\n") {
+				t.Errorf("%s fallback at depth %d: filled with %q, want %q", c.fallback, depth, got, c.fill)
+			}
+			if level := trace(r); !contains(level, fmt.Sprintf("keep %d plane1", depth+1)) {
+				t.Errorf("%s fallback at depth %d: no fill at level %d in %q", c.fallback, depth, depth+1, level)
+			}
+			captures := len(r.Filter("capture"))
+			if (captures == 1) != c.capture || captures > 1 {
+				t.Errorf("%s fallback: %d frame captures", c.fallback, captures)
+			}
+		}
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package draw
+
+import (
+	"flag"
+	"fmt"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"github.com/GlenKelley/portal/render"
+	"github.com/GlenKelley/portal/world"
+	glm "github.com/Jragonmiris/mathgl"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images")
+
+// room encloses a level manifest's portals in colored walls six units out
+// from the origin on each side, under a ceiling, so no background shows.
+func room(s *render.Software, scene *gtk.Model) {
+	walls := []struct {
+		center, normal, planev glm.Vec4d
+		color                  gtk.Color
+	}{
+		{glm.Vec4d{0, 2, -6, 1}, glm.Vec4d{0, 0, 1, 0}, glm.Vec4d{1, 0, 0, 0}, gtk.Color{0.8, 0.2, 0.2, 1}},
+		{glm.Vec4d{0, 2, 6, 1}, glm.Vec4d{0, 0, -1, 0}, glm.Vec4d{-1, 0, 0, 0}, gtk.Color{0.2, 0.7, 0.2, 1}},
+		{glm.Vec4d{6, 2, 0, 1}, glm.Vec4d{-1, 0, 0, 0}, glm.Vec4d{0, 0, 1, 0}, gtk.Color{0.2, 0.3, 0.8, 1}},
+		{glm.Vec4d{-6, 2, 0, 1}, glm.Vec4d{1, 0, 0, 0}, glm.Vec4d{0, 0, -1, 0}, gtk.Color{0.8, 0.7, 0.2, 1}},
+		{glm.Vec4d{0, 4, 0, 1}, glm.Vec4d{0, -1, 0, 0}, glm.Vec4d{1, 0, 0, 0}, gtk.Color{0.9, 0.9, 0.9, 1}},
+	}
+	for i, w := range walls {
+		q := portal.Quad{w.center, w.normal, w.planev, glm.Vec4d{6, 6, 1, 0}, portal.Rectangle{}}
+		vs, ns := q.Mesh()
+		name := fmt.Sprintf("wall_%d", i)
+		scene.AddGeometry(s.NewSurface(name, vs, ns, nil, q.Elements(), &render.Material{Name: name, Diffuse: w.color}))
+	}
+}
+
+// golden draws a level seen from its spawn with the Software renderer and
+// compares the frame with testdata/name.png, which -update rewrites.
+func golden(t *testing.T, level, name string, depth int) {
+	s := render.NewSoftware(160, 120, map[string]render.Shader{
+		ProgramScene:    render.SceneShader{},
+		ProgramFill:     render.FillShader{},
+		ProgramFallback: render.FallbackShader{},
+	})
+	w := world.New(world.DefaultConstants)
+	scene, problems, err := w.Load(filepath.Join("testdata", level), s.NewSurface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = problems.Err(); err != nil {
+		t.Fatal(err)
+	}
+	room(s, scene)
+	p := New(s, w, scene, Options{RecursionDepth: depth, Fallback: FallbackColor, FallbackColor: gtk.Color{1, 0, 1, 1}, Far: 100})
+	p.Draw(glm.Perspectived(w.Constants.PlayerFOV, 4.0/3, 0.001, 100), 160, 120)
+
+	filename := filepath.Join("testdata", name+".png")
+	if *update {
+		file, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Color.WritePNG(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := render.LoadImage(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// allow for rounding along the edges of triangles
+	if n := render.Diff(s.Color.RGBA(), want.RGBA(), 2); n > 160*120/200 {
+		t.Errorf("%d pixels differ from %s", n, filename)
+	}
+}
+
+// The spawn faces portal 1 on the north wall, which looks out of portal 2 on
+// the east wall, across the room to the yellow west wall.
+func TestGoldenThroughPortal(t *testing.T) {
+	golden(t, "level.json", "through_portal", 1)
+}
+
+// Portals on the north and south walls look into each other, each view
+// holding a smaller one until the magenta fallback.
+func TestGoldenRecursive(t *testing.T) {
+	golden(t, "hall.json", "recursive", 3)
+}
@@ -0,0 +1,217 @@
+package portal
+
+import (
+	"fmt"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"sort"
+)
+
+// Reverse turns the quad half way about its up axis, so it faces the other way
+// through the same horizon.
+func (q *Quad) Reverse() Quad {
+	return Quad{q.Center, q.Normal.Mul(-1), q.PlaneV.Mul(-1), q.Scale, q.Shape}
+}
+
+// NewPortal links the horizon entry to the horizon exit. Entering entry from
+// the front leaves exit from its front, heading away from it.
+func NewPortal(entry, exit Quad) Portal {
+	pair := PortalTransform(entry, exit.Reverse())
+	return Portal{entry, pair.BA, pair.ALocal, PreserveSpeed}
+}
+
+// Network holds portal horizons by id, the id each one exits through and how
+// each carries motion. Horizons without a transit preserve speed.
+type Network struct {
+	Horizons map[int]Quad
+	Links    map[int]int
+	Transits map[int]Transit
+}
+
+func NewNetwork() Network {
+	return Network{map[int]Quad{}, map[int]int{}, map[int]Transit{}}
+}
+
+// Link makes a and b exit through each other.
+func (n *Network) Link(a, b int) {
+	n.Links[a] = b
+	n.Links[b] = a
+}
+
+func (n *Network) Ids() []int {
+	ids := make([]int, 0, len(n.Horizons))
+	for id := range n.Horizons {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// NextId is an id greater than any horizon or link in the network.
+func (n *Network) NextId() int {
+	next := 0
+	for id, exit := range n.Links {
+		if id >= next {
+			next = id + 1
+		}
+		if exit >= next {
+			next = exit + 1
+		}
+	}
+	for id := range n.Horizons {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	return next
+}
+
+// Portals builds a portal for every horizon whose exit is present, in id
+// order, along with the id of each.
+func (n *Network) Portals() ([]Portal, []int) {
+	portals := []Portal{}
+	ids := []int{}
+	for _, id := range n.Ids() {
+		link, linked := n.Links[id]
+		exit, ok := n.Horizons[link]
+		if !linked || !ok {
+			continue
+		}
+		p := NewPortal(n.Horizons[id], exit)
+		p.Transit = n.Transits[id]
+		portals = append(portals, p)
+		ids = append(ids, id)
+	}
+	return portals, ids
+}
+
+// SurfaceOffset lifts placed horizons off their surface so they don't fight
+// it for depth.
+const SurfaceOffset = 0.01
+
+// Orient builds a horizon of the given half extents lying on a surface at
+// point with outward normal. Its up axis follows up as closely as the surface
+// allows, and its front faces out of the surface.
+func Orient(point, normal, up glm.Vec4d, extents glm.Vec2d, shape Shape) (Quad, error) {
+	inward, err := unitDirection(normal.Mul(-1))
+	if err != nil {
+		return Quad{}, err
+	}
+	u := glm.Vec3d{up[0], up[1], up[2]}
+	u = u.Sub(inward.Mul(u.Dot(inward)))
+	if u.Len() < 1e-6 {
+		u = orthogonalAxis(inward)
+	}
+	u = u.Normalize()
+	planev := u.Cross(inward)
+	center := point.Add(glm.Vec4d{-inward[0], -inward[1], -inward[2], 0}.Mul(SurfaceOffset))
+	center[3] = 1
+	return Quad{
+		center,
+		glm.Vec4d{inward[0], inward[1], inward[2], 0},
+		glm.Vec4d{planev[0], planev[1], planev[2], 0},
+		glm.Vec4d{extents[0], extents[1], 1, 0},
+		shape,
+	}, nil
+}
+
+// worldOutline is the outline of the horizon in world space.
+func (q *Quad) worldOutline() []glm.Vec4d {
+	m := q.Matrix()
+	outline := q.shape().Outline()
+	points := make([]glm.Vec4d, len(outline))
+	for i, p := range outline {
+		points[i] = m.Mul4x1(glm.Vec4d{p[0], p[1], 0, 1})
+	}
+	return points
+}
+
+// Supports checks that the whole horizon q rests flat against the scene's
+// triangles, rather than hanging over an edge or a gap.
+func (s *Scene) Supports(q Quad) error {
+	probe := 2 * SurfaceOffset
+	points := append(q.worldOutline(), q.Center)
+	for _, p := range points {
+		origin := p.Sub(q.Normal.Mul(probe))
+		tri, t := s.nearestTriangle(origin, q.Normal, 2*probe)
+		if tri < 0 {
+			return fmt.Errorf("portal hangs off the surface at %v", p)
+		}
+		n := s.Triangles[tri].Normal()
+		if math.Abs(n.Dot(q.Normal)) < 0.99 || math.Abs(t-probe-SurfaceOffset) > SurfaceOffset {
+			return fmt.Errorf("portal surface is not flat at %v", p)
+		}
+	}
+	return nil
+}
+
+// Overlaps reports whether two horizons lying in the same plane share any area.
+func Overlaps(a, b Quad) bool {
+	if math.Abs(a.Normal.Dot(b.Normal)) < 0.99 || math.Abs(b.Center.Sub(a.Center).Dot(a.Normal)) > SurfaceOffset {
+		return false
+	}
+	planar := func(q Quad) []glm.Vec2d {
+		up := a.Up()
+		points := []glm.Vec2d{}
+		for _, p := range q.worldOutline() {
+			d := p.Sub(a.Center)
+			points = append(points, glm.Vec2d{d.Dot(a.PlaneV), d.Dot(up)})
+		}
+		return points
+	}
+	pa, pb := planar(a), planar(b)
+	return !separated(pa, pb) && !separated(pb, pa)
+}
+
+// touchTolerance lets horizons which only share an edge sit side by side.
+const touchTolerance = 1e-9
+
+// separated looks for a separating axis among the edge normals of the convex
+// polygon a.
+func separated(a, b []glm.Vec2d) bool {
+	for i := range a {
+		e := a[(i+1)%len(a)].Sub(a[i])
+		axis := glm.Vec2d{-e[1], e[0]}
+		minA, maxA := project(a, axis)
+		minB, maxB := project(b, axis)
+		if maxA <= minB+touchTolerance || maxB <= minA+touchTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+func project(points []glm.Vec2d, axis glm.Vec2d) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		d := p.Dot(axis)
+		min = math.Min(min, d)
+		max = math.Max(max, d)
+	}
+	return min, max
+}
+
+// Place moves horizon id onto the surface a ray cast stopped on, oriented by
+// the caller's up vector given in the ray's starting space. It fails if the
+// ray did not stop on a surface, the horizon would not fit on the surface, or
+// it would overlap another horizon.
+func (n *Network) Place(scene *Scene, id int, hits []RayHit, up glm.Vec4d, extents glm.Vec2d, shape Shape) error {
+	if len(hits) == 0 || hits[len(hits)-1].Triangle < 0 {
+		return fmt.Errorf("no surface to place portal %d on", id)
+	}
+	hit := hits[len(hits)-1]
+	q, err := Orient(hit.Point, hit.Normal, hit.Transform.Mul4x1(up), extents, shape)
+	if err != nil {
+		return err
+	}
+	if err := scene.Supports(q); err != nil {
+		return err
+	}
+	for other, h := range n.Horizons {
+		if other != id && Overlaps(q, h) {
+			return fmt.Errorf("portal %d would overlap portal %d", id, other)
+		}
+	}
+	n.Horizons[id] = q
+	return nil
+}
@@ -0,0 +1,81 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"testing"
+)
+
+func horizonAt(x, y float64, shape Shape) Quad {
+	return Quad{
+		glm.Vec4d{x, y, 0, 1},
+		glm.Vec4d{0, 0, 1, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{1, 1, 1, 0},
+		shape,
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	a := horizonAt(0, 0, Rectangle{})
+	cases := []struct {
+		name string
+		b    Quad
+		want bool
+	}{
+		{"gapped", horizonAt(2.5, 0, Rectangle{}), false},
+		{"gapped above", horizonAt(0, 2.5, Rectangle{}), false},
+		{"gapped diagonally", horizonAt(2.5, 2.5, Rectangle{}), false},
+		{"touching", horizonAt(2, 0, Rectangle{}), false},
+		{"touching a corner", horizonAt(2, 2, Rectangle{}), false},
+		{"overlapping", horizonAt(1.5, 0, Rectangle{}), true},
+		{"overlapping a corner", horizonAt(1.5, -1.5, Rectangle{}), true},
+		{"same place", horizonAt(0, 0, Rectangle{}), true},
+		{"ellipse inside", horizonAt(0.5, 0.5, Ellipse{}), true},
+		{"ellipse beside", horizonAt(2.5, 0, Ellipse{}), false},
+		{"ellipse past the corner", horizonAt(1.8, 1.8, Ellipse{}), false},
+	}
+	for _, c := range cases {
+		if got := Overlaps(a, c.b); got != c.want {
+			t.Errorf("%s: Overlaps(a, b) = %v, want %v", c.name, got, c.want)
+		}
+		if got := Overlaps(c.b, a); got != c.want {
+			t.Errorf("%s: Overlaps(b, a) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestOverlapsOtherPlane(t *testing.T) {
+	a := horizonAt(0, 0, Rectangle{})
+	b := a
+	b.Center = glm.Vec4d{0, 0, 1, 1}
+	if Overlaps(a, b) {
+		t.Error("parallel horizons a unit apart overlap")
+	}
+	b = a
+	b.Normal = glm.Vec4d{1, 0, 0, 0}
+	b.PlaneV = glm.Vec4d{0, 0, -1, 0}
+	if Overlaps(a, b) {
+		t.Error("crossing horizons overlap")
+	}
+}
+
+func TestPlaceRejectsOverlap(t *testing.T) {
+	s := &Scene{Triangles: wall(0, -1, 10)}
+	n := NewNetwork()
+	place := func(id int, x float64) error {
+		hits := s.Raycast(glm.Vec4d{x, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 10, 0)
+		return n.Place(s, id, hits, glm.Vec4d{0, 1, 0, 0}, glm.Vec2d{1, 1}, Rectangle{})
+	}
+	if err := place(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := place(2, 1.5); err == nil {
+		t.Error("placed a portal overlapping portal 1")
+	}
+	if err := place(2, 3); err != nil {
+		t.Errorf("could not place a portal beside portal 1: %v", err)
+	}
+	if err := place(1, 0.5); err != nil {
+		t.Errorf("could not move portal 1 over its own place: %v", err)
+	}
+}
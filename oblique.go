@@ -0,0 +1,69 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Plane is the horizon's plane as (n, d), positive on the side its normal
+// points to, beyond the portal.
+func (q *Quad) Plane() glm.Vec4d {
+	n := q.Normal.Normalize()
+	return glm.Vec4d{n[0], n[1], n[2], -n.Dot(q.Center)}
+}
+
+// TransformPlane carries a plane into the space m maps points into.
+func TransformPlane(plane glm.Vec4d, m glm.Mat4d) glm.Vec4d {
+	inv := m.Inv()
+	p := glm.Vec4d{}
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			p[j] += plane[i] * inv[j*4+i]
+		}
+	}
+	return p
+}
+
+func sign(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// ObliqueProjection replaces the near plane of a perspective projection with
+// plane, given in camera space, keeping the far plane's corners in place. Only
+// points on the positive side of the plane survive clipping. The camera has to
+// be on the negative side, otherwise projection is returned unchanged.
+func ObliqueProjection(projection glm.Mat4d, plane glm.Vec4d) glm.Mat4d {
+	if plane[3] >= 0 {
+		return projection
+	}
+	m := projection
+	q := glm.Vec4d{
+		(sign(plane[0]) + m[8]) / m[0],
+		(sign(plane[1]) + m[9]) / m[5],
+		-1,
+		(1 + m[10]) / m[14],
+	}
+	dot := plane.Dot(q)
+	if math.Abs(dot) < 1e-12 {
+		return projection
+	}
+	c := plane.Mul(2 / dot)
+	m[2] = c[0]
+	m[6] = c[1]
+	m[10] = c[2] + 1
+	m[14] = c[3]
+	return m
+}
+
+// Projection is the projection to render the view through the portal with,
+// clipping away everything between the camera and the horizon. view maps the
+// space the portal lives in to camera space.
+func (p *Portal) Projection(projection, view glm.Mat4d) glm.Mat4d {
+	return ObliqueProjection(projection, TransformPlane(p.EventHorizon.Plane(), view))
+}
@@ -0,0 +1,80 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"testing"
+)
+
+func ndcDepth(m glm.Mat4d, p glm.Vec4d) float64 {
+	c := m.Mul4x1(p)
+	return c[2] / c[3]
+}
+
+// pointsOn lists camera space points of the plane through center, spanned
+// by u and v, which are in front of the camera.
+func pointsOn(center, u, v glm.Vec4d) []glm.Vec4d {
+	points := []glm.Vec4d{}
+	for _, a := range []float64{-1, 0, 1} {
+		for _, b := range []float64{-1, 0, 1} {
+			points = append(points, center.Add(u.Mul(a)).Add(v.Mul(b)))
+		}
+	}
+	return points
+}
+
+func TestObliqueNearPlaneIsPortalPlane(t *testing.T) {
+	projection := glm.Perspectived(70, 4.0/3, 0.1, 100)
+	q := Quad{
+		glm.Vec4d{0.5, -0.2, -3, 1},
+		glm.Vec4d{0.3, 0.2, -1, 0}.Normalize(),
+		glm.Vec4d{1, 0, 0.3, 0}.Normalize(),
+		glm.Vec4d{1, 1, 1, 0},
+		Rectangle{},
+	}
+	m := ObliqueProjection(projection, q.Plane())
+	if m == projection {
+		t.Fatal("projection unchanged for a plane in front of the camera")
+	}
+	u, v := q.PlaneV, q.Up()
+	for _, p := range pointsOn(q.Center, u, v) {
+		if d := ndcDepth(m, p); !approx(d, -1) {
+			t.Errorf("point %v of the horizon plane has depth %v, want -1 on the near plane", p, d)
+		}
+		beyond := p.Add(q.Normal.Mul(0.5))
+		if d := ndcDepth(m, beyond); d <= -1 || d >= 1 {
+			t.Errorf("point %v beyond the horizon has depth %v, want it inside the view", beyond, d)
+		}
+		before := p.Sub(q.Normal.Mul(0.5))
+		if d := ndcDepth(m, before); d >= -1 {
+			t.Errorf("point %v before the horizon has depth %v, want it clipped", before, d)
+		}
+	}
+	// the far plane's corner keeps its place
+	far := glm.Vec4d{0, 0, -100, 1}
+	if d := ndcDepth(m, far); d > 1+1e-9 {
+		t.Errorf("far point %v has depth %v, want it kept", far, d)
+	}
+}
+
+func TestObliqueCameraBeyondPlane(t *testing.T) {
+	projection := glm.Perspectived(70, 1, 0.1, 100)
+	// the camera is on the positive side of this plane
+	plane := glm.Vec4d{0, 0, 1, 3}
+	if m := ObliqueProjection(projection, plane); m != projection {
+		t.Error("projection changed for a plane the camera is beyond")
+	}
+}
+
+func TestPortalProjection(t *testing.T) {
+	projection := glm.Perspectived(70, 1, 0.1, 100)
+	horizon := facing(t, 0, -4, glm.Vec4d{0, 0, -1, 0})
+	p := NewPortal(horizon, horizon)
+	// the camera stands at x=1, looking down -z
+	view := glm.Translate3Dd(-1, 0, 0)
+	m := p.Projection(projection, view)
+	for _, point := range pointsOn(horizon.Center, horizon.PlaneV, horizon.Up()) {
+		if d := ndcDepth(m, view.Mul4x1(point)); !approx(d, -1) {
+			t.Errorf("point %v of the horizon has depth %v, want -1", point, d)
+		}
+	}
+}
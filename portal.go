@@ -12,45 +12,82 @@ type Quad struct {
 	Normal glm.Vec4d
 	PlaneV glm.Vec4d
 	Scale  glm.Vec4d
+	Shape  Shape
+}
+
+func (q *Quad) shape() Shape {
+	if q.Shape == nil {
+		return Rectangle{}
+	}
+	return q.Shape
 }
 
 func (q *Quad) Mesh() ([]float64, []float64) {
-	px := q.PlaneV.Mul(q.Scale[0])
-	py := Cross3Dv(q.PlaneV, q.Normal).Mul(q.Scale[1])
-	a := q.Center.Sub(px).Sub(py)
-	b := q.Center.Add(px).Sub(py)
-	c := q.Center.Sub(px).Add(py)
-	d := q.Center.Add(px).Add(py)
-	o := q.Center
+	m := q.Matrix()
 	n := q.Center.Add(q.Normal.Mul(q.Scale[2] * 0.2))
-	return []float64{
-			a[0], a[1], a[2],
-			b[0], b[1], b[2],
-			c[0], c[1], c[2],
-			d[0], d[1], d[2],
-			o[0], o[1], o[2],
-			n[0], n[1], n[2],
-		}, []float64{
-			n[0], n[1], n[2],
-			n[0], n[1], n[2],
-			n[0], n[1], n[2],
-			n[0], n[1], n[2],
-			n[0], n[1], n[2],
-			n[0], n[1], n[2],
-		}
+	outline := q.shape().Outline()
+	vs := make([]float64, 0, 3*(len(outline)+2))
+	for _, p := range outline {
+		v := m.Mul4x1(glm.Vec4d{p[0], p[1], 0, 1})
+		vs = append(vs, v[0], v[1], v[2])
+	}
+	o := q.Center
+	vs = append(vs, o[0], o[1], o[2], n[0], n[1], n[2])
+	ns := make([]float64, 0, len(vs))
+	for i := 0; i < len(vs); i += 3 {
+		ns = append(ns, n[0], n[1], n[2])
+	}
+	return vs, ns
+}
+
+func (q *Quad) Elements() map[gl.Enum][]int16 {
+	return q.shape().Elements()
 }
 
-var QuadElements = map[gl.Enum][]int16{
-	gl.TRIANGLE_STRIP: []int16{0, 1, 2, 3},
-	gl.LINES:          []int16{0, 1, 1, 3, 3, 2, 2, 0, 0, 3, 1, 2, 4, 5},
+// Contains tests a point of the horizon plane given in local horizon space.
+func (q *Quad) Contains(x, y float64) bool {
+	return q.shape().Contains(x, y)
+}
+
+// QuadElements indexes the mesh of a rectangular horizon.
+var QuadElements = convexElements(4)
+
+// Up completes the right handed frame (PlaneV, Up, Normal).
+func (q *Quad) Up() glm.Vec4d {
+	return Cross3Dv(q.Normal, q.PlaneV)
 }
 
+// Matrix maps local quad space, where the horizon spans [-1,1], into world space.
+func (q *Quad) Matrix() glm.Mat4d {
+	f := NewPortalFrame(*q)
+	return f.ToWorld()
+}
+
+// Inverse maps world space into local quad space without inverting Matrix.
+func (q *Quad) Inverse() glm.Mat4d {
+	f := NewPortalFrame(*q)
+	return f.ToLocal()
+}
+
+// Apply transforms the quad by an affine matrix, re-orthonormalizing the frame
+// around the transformed horizon plane and re-deriving the extents from it.
 func (q *Quad) Apply(t glm.Mat4d) Quad {
+	x := t.Mul4x1(q.PlaneV.Mul(q.Scale[0]))
+	y := t.Mul4x1(q.Up().Mul(q.Scale[1]))
+	z := t.Mul4x1(q.Normal.Mul(q.Scale[2]))
+
+	planev := x.Normalize()
+	normal := Cross3Dv(x, y).Normalize()
+	if normal.Dot(z) < 0 {
+		normal = normal.Mul(-1)
+	}
+	up := Cross3Dv(normal, planev)
 	return Quad{
-		q.Center, //t.Mul4x1(q.Center),
-		t.Mul4x1(q.Normal),
-		t.Mul4x1(q.PlaneV),
-		q.Scale, //t.Mul4x1(q.Scale),
+		t.Mul4x1(q.Center),
+		normal,
+		planev,
+		glm.Vec4d{x.Len(), math.Abs(y.Dot(up)), math.Abs(z.Dot(normal)), 0},
+		q.Shape,
 	}
 }
 
@@ -58,6 +95,7 @@ type Portal struct {
 	EventHorizon Quad
 	Transform    glm.Mat4d
 	Portalview   glm.Mat4d
+	Transit      Transit
 }
 
 func Cross3D(a, b glm.Vec4d) glm.Vec3d {
@@ -75,46 +113,182 @@ func NearZero(v glm.Vec3d) bool {
 	return v.ApproxEqual(glm.Vec3d{})
 }
 
-func RotationBetweenNormals(n1, n2 glm.Vec4d) glm.Mat4d {
-	axis := Cross3D(n1, n2)
-	dot := n1.Dot(n2)
-	if !NearZero(axis) {
-		angle := math.Acos(dot)
-		return glm.HomogRotate3Dd(angle, axis.Normalize())
-	} else if dot < 0 {
-		for e := 0; e < 3; e++ {
-			v := glm.Vec4d{}
-			v[e] = 1
-			cross := Cross3D(n1, v)
-			if !NearZero(cross) {
-				return glm.HomogRotate3Dd(math.Pi, cross.Normalize())
-			}
+// RotationBetweenNormals is the shortest rotation taking direction n1 onto n2.
+func RotationBetweenNormals(n1, n2 glm.Vec4d) (glm.Mat4d, error) {
+	q, err := ShortestArc(n1, n2, glm.Vec3d{})
+	if err != nil {
+		return glm.Ident4d(), err
+	}
+	return q.Mat4(), nil
+}
+
+// antiparallelLimit is the value of 1+dot(n1,n2) below which the two normals
+// are treated as opposite and the rotation axis has to be chosen.
+const antiparallelLimit = 1e-12
+
+// ShortestArc is the unit quaternion rotating direction n1 onto n2. When the
+// directions are opposite the half turn is made about fallback, or about the
+// coordinate axis most orthogonal to n1 if fallback is zero or parallel to n1.
+func ShortestArc(n1, n2 glm.Vec4d, fallback glm.Vec3d) (glm.Quatd, error) {
+	a, err := unitDirection(n1)
+	if err != nil {
+		return glm.QuatIdentd(), err
+	}
+	b, err := unitDirection(n2)
+	if err != nil {
+		return glm.QuatIdentd(), err
+	}
+	w := 1 + a.Dot(b)
+	if w > antiparallelLimit {
+		return glm.Quatd{w, a.Cross(b)}.Normalize(), nil
+	}
+	axis := fallback.Sub(a.Mul(fallback.Dot(a)))
+	if axis.Len() < 1e-6 {
+		axis = orthogonalAxis(a)
+	}
+	return glm.Quatd{0, axis.Normalize()}, nil
+}
+
+func unitDirection(v glm.Vec4d) (glm.Vec3d, error) {
+	v3 := glm.Vec3d{v[0], v[1], v[2]}
+	l := v3.Len()
+	if l == 0 || math.IsNaN(l) || math.IsInf(l, 0) {
+		return glm.Vec3d{}, fmt.Errorf("degenerate normal %v", v)
+	}
+	return v3.Mul(1 / l), nil
+}
+
+// orthogonalAxis is a unit vector perpendicular to the unit vector n, built
+// from the coordinate axis n has the smallest component along.
+func orthogonalAxis(n glm.Vec3d) glm.Vec3d {
+	e := glm.Vec3d{}
+	min := 0
+	for i := 1; i < 3; i++ {
+		if math.Abs(n[i]) < math.Abs(n[min]) {
+			min = i
 		}
-		panic(fmt.Sprintln("no orthogonal axis found for normal", n1))
 	}
-	return glm.Ident4d()
+	e[min] = 1
+	return n.Cross(e).Normalize()
 }
-func PortalTransform(a, b Quad) (glm.Mat4d, glm.Mat4d, glm.Mat4d, glm.Mat4d) {
-	zn := glm.Vec4d{0, 0, 1, 0}
-	xn := glm.Vec4d{1, 0, 0, 0}
 
-	translateAZ := glm.Translate3Dd(-a.Center[0], -a.Center[1], -a.Center[2])
-	rotationAZ := RotationBetweenNormals(a.Normal, zn)
-	rotationAXZ := RotationBetweenNormals(rotationAZ.Mul4x1(a.PlaneV), xn)
-	scaleAZ := glm.Scale3Dd(1.0/a.Scale[0], 1.0/a.Scale[1], 1.0/a.Scale[2])
+// PortalFrame is the orthonormal basis (PlaneV, up, Normal) of a portal horizon
+// together with its extents along each axis.
+type PortalFrame struct {
+	Origin glm.Vec4d
+	X      glm.Vec4d
+	Y      glm.Vec4d
+	Z      glm.Vec4d
+	Scale  glm.Vec4d
+}
 
-	AZ := scaleAZ.Mul4(rotationAXZ).Mul4(rotationAZ).Mul4(translateAZ)
-	ZA := AZ.Inv()
+func NewPortalFrame(q Quad) PortalFrame {
+	z := q.Normal.Normalize()
+	x := q.PlaneV.Sub(z.Mul(q.PlaneV.Dot(z))).Normalize()
+	y := Cross3Dv(z, x)
+	return PortalFrame{q.Center, x, y, z, q.Scale}
+}
 
-	translateBZ := glm.Translate3Dd(-b.Center[0], -b.Center[1], -b.Center[2])
-	rotationBZ := RotationBetweenNormals(b.Normal, zn)
-	rotationBXZ := RotationBetweenNormals(rotationBZ.Mul4x1(b.PlaneV), xn)
-	scaleBZ := glm.Scale3Dd(1.0/b.Scale[0], 1.0/b.Scale[1], 1.0/b.Scale[2])
+// ToWorld maps local horizon space into world space.
+func (f *PortalFrame) ToWorld() glm.Mat4d {
+	x := f.X.Mul(f.Scale[0])
+	y := f.Y.Mul(f.Scale[1])
+	z := f.Z.Mul(f.Scale[2])
+	o := f.Origin
+	return glm.Mat4d{
+		x[0], x[1], x[2], 0,
+		y[0], y[1], y[2], 0,
+		z[0], z[1], z[2], 0,
+		o[0], o[1], o[2], 1,
+	}
+}
 
-	BZ := scaleBZ.Mul4(rotationBXZ).Mul4(rotationBZ).Mul4(translateBZ)
-	ZB := BZ.Inv()
+// ToLocal maps world space into local horizon space.
+func (f *PortalFrame) ToLocal() glm.Mat4d {
+	x := f.X.Mul(1 / f.Scale[0])
+	y := f.Y.Mul(1 / f.Scale[1])
+	z := f.Z.Mul(1 / f.Scale[2])
+	o := f.Origin
+	return glm.Mat4d{
+		x[0], y[0], z[0], 0,
+		x[1], y[1], z[1], 0,
+		x[2], y[2], z[2], 0,
+		-x.Dot(o), -y.Dot(o), -z.Dot(o), 1,
+	}
+}
+
+// Rotation is the rotation which carries frame f onto frame g.
+func (f *PortalFrame) Rotation(g PortalFrame) glm.Quatd {
+	var m [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			m[r][c] = g.X[r]*f.X[c] + g.Y[r]*f.Y[c] + g.Z[r]*f.Z[c]
+		}
+	}
+	return quatFromRotation(m)
+}
+
+// quatFromRotation converts a row major rotation matrix into a quaternion,
+// pivoting on the largest diagonal term to stay stable near half turns.
+func quatFromRotation(m [3][3]float64) glm.Quatd {
+	var w, x, y, z float64
+	trace := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case trace > 0:
+		s := math.Sqrt(trace+1) * 2
+		w = s / 4
+		x = (m[2][1] - m[1][2]) / s
+		y = (m[0][2] - m[2][0]) / s
+		z = (m[1][0] - m[0][1]) / s
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := math.Sqrt(1+m[0][0]-m[1][1]-m[2][2]) * 2
+		w = (m[2][1] - m[1][2]) / s
+		x = s / 4
+		y = (m[0][1] + m[1][0]) / s
+		z = (m[0][2] + m[2][0]) / s
+	case m[1][1] > m[2][2]:
+		s := math.Sqrt(1+m[1][1]-m[0][0]-m[2][2]) * 2
+		w = (m[0][2] - m[2][0]) / s
+		x = (m[0][1] + m[1][0]) / s
+		y = s / 4
+		z = (m[1][2] + m[2][1]) / s
+	default:
+		s := math.Sqrt(1+m[2][2]-m[0][0]-m[1][1]) * 2
+		w = (m[1][0] - m[0][1]) / s
+		x = (m[0][2] + m[2][0]) / s
+		y = (m[1][2] + m[2][1]) / s
+		z = s / 4
+	}
+	return glm.Quatd{w, glm.Vec3d{x, y, z}}.Normalize()
+}
+
+// PortalPair holds the transforms between two linked horizons A and B.
+type PortalPair struct {
+	AB     glm.Mat4d // carries world space at A to world space at B
+	BA     glm.Mat4d // carries world space at B to world space at A
+	ALocal glm.Mat4d // world space into A's horizon space
+	BLocal glm.Mat4d // world space into B's horizon space
 
-	AB := ZB.Mul4(AZ)
-	BA := ZA.Mul4(BZ)
-	return AB, BA, AZ, BZ
+	// decomposition of AB
+	Rotation    glm.Quatd
+	Translation glm.Vec4d
+	Scale       glm.Vec4d // per horizon axis, B.Scale / A.Scale
+}
+
+func PortalTransform(a, b Quad) PortalPair {
+	fa := NewPortalFrame(a)
+	fb := NewPortalFrame(b)
+	AZ := a.Inverse()
+	BZ := b.Inverse()
+	AB := b.Matrix().Mul4(AZ)
+	BA := a.Matrix().Mul4(BZ)
+	return PortalPair{
+		AB,
+		BA,
+		AZ,
+		BZ,
+		fa.Rotation(fb),
+		glm.Vec4d{AB[12], AB[13], AB[14], 0},
+		glm.Vec4d{b.Scale[0] / a.Scale[0], b.Scale[1] / a.Scale[1], b.Scale[2] / a.Scale[2], 0},
+	}
 }
@@ -0,0 +1,383 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func approxVec(a, b glm.Vec4d) bool {
+	for i := range a {
+		if !approx(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func rotate(q glm.Quatd, v glm.Vec4d) glm.Vec4d {
+	r := q.Rotate(glm.Vec3d{v[0], v[1], v[2]})
+	return glm.Vec4d{r[0], r[1], r[2], 0}
+}
+
+func TestShortestArcIdentical(t *testing.T) {
+	n := glm.Vec4d{0, 0, 1, 0}
+	q, err := ShortestArc(n, n, glm.Vec3d{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.W, 1) || !approx(q.V.Len(), 0) {
+		t.Errorf("rotation between equal normals is %v, want identity", q)
+	}
+}
+
+func TestShortestArcAntiparallel(t *testing.T) {
+	normals := []glm.Vec4d{
+		{0, 0, 1, 0},
+		{1, 0, 0, 0},
+		{0, -1, 0, 0},
+		glm.Vec4d{1, 2, 3, 0}.Normalize(),
+	}
+	for _, n := range normals {
+		opposite := n.Mul(-1)
+		// the nearly antiparallel pair must not fall into the degenerate branch
+		nearly := opposite.Add(glm.Vec4d{1e-9, 0, 0, 0})
+		for _, m := range []glm.Vec4d{opposite, nearly} {
+			q, err := ShortestArc(n, m, glm.Vec3d{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !approx(q.Len(), 1) {
+				t.Errorf("%v to %v: quaternion %v is not unit", n, m, q)
+			}
+			r := rotate(q, n)
+			if r.Sub(m.Normalize()).Len() > 1e-6 {
+				t.Errorf("%v to %v: rotated to %v", n, m, r)
+			}
+		}
+	}
+}
+
+func randomUnit(r *rand.Rand) glm.Vec4d {
+	return glm.Vec4d{r.NormFloat64(), r.NormFloat64(), r.NormFloat64(), 0}.Normalize()
+}
+
+func TestShortestArcRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		n1 := randomUnit(r)
+		var n2 glm.Vec4d
+		switch i % 3 {
+		case 0:
+			n2 = randomUnit(r)
+		case 1:
+			// nearly antiparallel, by less than antiparallelLimit resolves
+			n2 = n1.Mul(-1).Add(randomUnit(r).Mul(1e-7)).Normalize()
+		case 2:
+			// nearly parallel
+			n2 = n1.Add(randomUnit(r).Mul(1e-7)).Normalize()
+		}
+		q, err := ShortestArc(n1, n2, glm.Vec3d{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(q.Len(), 1) {
+			t.Errorf("%v to %v: quaternion %v is not unit", n1, n2, q)
+		}
+		if d := rotate(q, n1).Sub(n2).Len(); d > 1e-6 {
+			t.Errorf("%v to %v: quaternion misses by %v", n1, n2, d)
+		}
+		m, err := RotationBetweenNormals(n1, n2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := m.Mul4x1(n1).Sub(n2).Len(); d > 1e-6 {
+			t.Errorf("%v to %v: matrix misses by %v", n1, n2, d)
+		}
+		if !approx(m.Det(), 1) {
+			t.Errorf("%v to %v: matrix %v is not a rotation", n1, n2, m)
+		}
+	}
+}
+
+func TestShortestArcFallback(t *testing.T) {
+	n := glm.Vec4d{0, 0, 1, 0}
+	up := glm.Vec3d{0, 1, 0}
+	q, err := ShortestArc(n, n.Mul(-1), up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.V.Normalize().ApproxEqual(up) {
+		t.Errorf("half turn about %v, want fallback %v", q.V, up)
+	}
+	// a fallback parallel to the normal is replaced by an orthogonal axis
+	q, err = ShortestArc(n, n.Mul(-1), glm.Vec3d{0, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.V.Dot(glm.Vec3d{0, 0, 1}), 0) || !approx(q.V.Len(), 1) {
+		t.Errorf("half turn about %v, want an axis orthogonal to %v", q.V, n)
+	}
+}
+
+func TestShortestArcDegenerate(t *testing.T) {
+	bad := []glm.Vec4d{
+		{},
+		{math.NaN(), 0, 0, 0},
+		{math.Inf(1), 0, 0, 0},
+	}
+	for _, b := range bad {
+		if _, err := ShortestArc(b, glm.Vec4d{0, 0, 1, 0}, glm.Vec3d{}); err == nil {
+			t.Errorf("no error for normal %v", b)
+		}
+		if _, err := RotationBetweenNormals(glm.Vec4d{0, 0, 1, 0}, b); err == nil {
+			t.Errorf("no error for normal %v", b)
+		}
+	}
+}
+
+// approxQuad compares the frame and extents of two quads.
+func approxQuad(a, b Quad) bool {
+	return approxVec(a.Center, b.Center) && approxVec(a.Normal, b.Normal) &&
+		approxVec(a.PlaneV, b.PlaneV) && approxVec(a.Scale, b.Scale)
+}
+
+func approxMat(a, b glm.Mat4d) bool {
+	for i := range a {
+		if !approx(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// wallQuad is a horizon at (1, 2, 3) facing +x, 2 wide and 3 high.
+func wallQuad() Quad {
+	return Quad{
+		glm.Vec4d{1, 2, 3, 1},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{0, 0, -1, 0},
+		glm.Vec4d{2, 3, 1, 0},
+		nil,
+	}
+}
+
+func TestQuadApplyTranslation(t *testing.T) {
+	q := wallQuad()
+	moved := q.Apply(glm.Translate3Dd(4, -5, 6))
+	want := q
+	want.Center = glm.Vec4d{5, -3, 9, 1}
+	if !approxQuad(moved, want) {
+		t.Errorf("translated to %v, want %v", moved, want)
+	}
+}
+
+func TestQuadApplyRotationAboutPoint(t *testing.T) {
+	q := wallQuad()
+	// a quarter turn about the y axis through (1, 0, 0)
+	m := glm.Translate3Dd(1, 0, 0).Mul4(glm.HomogRotate3DYd(90)).Mul4(glm.Translate3Dd(-1, 0, 0))
+	turned := q.Apply(m)
+	want := Quad{
+		glm.Vec4d{4, 2, 0, 1},
+		glm.Vec4d{0, 0, -1, 0},
+		glm.Vec4d{-1, 0, 0, 0},
+		q.Scale,
+		nil,
+	}
+	if !approxQuad(turned, want) {
+		t.Errorf("turned to %v, want %v", turned, want)
+	}
+}
+
+func TestQuadApplyNonUniformScale(t *testing.T) {
+	q := wallQuad()
+	scaled := q.Apply(glm.Scale3Dd(5, 2, 3))
+	want := Quad{
+		glm.Vec4d{5, 4, 9, 1},
+		q.Normal,
+		q.PlaneV,
+		// the horizon's x runs along world z and its y along world y
+		glm.Vec4d{6, 6, 5, 0},
+		nil,
+	}
+	if !approxQuad(scaled, want) {
+		t.Errorf("scaled to %v, want %v", scaled, want)
+	}
+
+	// a slanted horizon is squashed out of shape, but its frame stays
+	// orthonormal with the normal across the squashed plane
+	slanted := Quad{glm.Vec4d{0, 0, 0, 1}, glm.Vec4d{1, 0, 1, 0}.Normalize(), glm.Vec4d{1, 0, -1, 0}.Normalize(), glm.Vec4d{1, 1, 1, 0}, nil}
+	s := glm.Scale3Dd(4, 1, 1)
+	squashed := slanted.Apply(s)
+	x := s.Mul4x1(slanted.PlaneV)
+	y := s.Mul4x1(slanted.Up())
+	if !approx(squashed.Normal.Len(), 1) || !approx(squashed.PlaneV.Len(), 1) || !approx(squashed.Normal.Dot(squashed.PlaneV), 0) {
+		t.Errorf("frame %v, %v is not orthonormal", squashed.PlaneV, squashed.Normal)
+	}
+	if !approx(squashed.Normal.Dot(x), 0) || !approx(squashed.Normal.Dot(y), 0) {
+		t.Errorf("normal %v is not across the squashed plane", squashed.Normal)
+	}
+	if !approx(squashed.Scale[0], x.Len()) {
+		t.Errorf("width %v, want %v", squashed.Scale[0], x.Len())
+	}
+}
+
+func TestQuadApplyInverse(t *testing.T) {
+	q := wallQuad()
+	transforms := []glm.Mat4d{
+		glm.Translate3Dd(4, -5, 6),
+		glm.HomogRotate3DZd(30).Mul4(glm.HomogRotate3DXd(-70)),
+		glm.Translate3Dd(1, 1, 1).Mul4(glm.HomogRotate3DYd(123)).Mul4(glm.Scale3Dd(2, 2, 2)),
+		glm.Scale3Dd(5, 2, 3),
+	}
+	for _, m := range transforms {
+		back := q.Apply(m)
+		back = back.Apply(m.Inv())
+		if !approxQuad(back, q) {
+			t.Errorf("%v: moved back to %v, want %v", m, back, q)
+		}
+	}
+}
+
+func TestQuadApplyAssociative(t *testing.T) {
+	q := wallQuad()
+	a := glm.Translate3Dd(0, 1, -2).Mul4(glm.HomogRotate3DYd(40))
+	b := glm.HomogRotate3DXd(25).Mul4(glm.Scale3Dd(3, 3, 3))
+	c := glm.Translate3Dd(7, 0, 0)
+	once := q.Apply(c.Mul4(b).Mul4(a))
+	stepwise := q.Apply(a)
+	stepwise = stepwise.Apply(b)
+	stepwise = stepwise.Apply(c)
+	grouped := q.Apply(b.Mul4(a))
+	grouped = grouped.Apply(c)
+	if !approxQuad(once, stepwise) || !approxQuad(once, grouped) {
+		t.Errorf("applied at once %v, step by step %v, grouped %v", once, stepwise, grouped)
+	}
+}
+
+func TestQuadMatrixInverse(t *testing.T) {
+	wall := wallQuad()
+	q := wall.Apply(glm.HomogRotate3DZd(35))
+	if !approxMat(q.Matrix().Mul4(q.Inverse()), glm.Ident4d()) || !approxMat(q.Inverse().Mul4(q.Matrix()), glm.Ident4d()) {
+		t.Error("Inverse does not invert Matrix")
+	}
+	// the horizon spans [-1,1] in local space
+	corner := q.Matrix().Mul4x1(glm.Vec4d{1, 1, 0, 1})
+	want := q.Center.Add(q.PlaneV.Mul(q.Scale[0])).Add(q.Up().Mul(q.Scale[1]))
+	if !approxVec(corner, want) {
+		t.Errorf("corner at %v, want %v", corner, want)
+	}
+}
+
+// floorQuad is a horizon at (-4, 0, 6) facing up, 1 wide and 2 deep.
+func floorQuad() Quad {
+	return Quad{
+		glm.Vec4d{-4, 0, 6, 1},
+		glm.Vec4d{0, 1, 0, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{1, 2, 1, 0},
+		nil,
+	}
+}
+
+func TestPortalTransformInverse(t *testing.T) {
+	pair := PortalTransform(wallQuad(), floorQuad())
+	if !approxMat(pair.AB.Mul4(pair.BA), glm.Ident4d()) || !approxMat(pair.BA.Mul4(pair.AB), glm.Ident4d()) {
+		t.Errorf("AB·BA is not the identity")
+	}
+}
+
+func TestPortalTransformFrames(t *testing.T) {
+	a, b := wallQuad(), floorQuad()
+	pair := PortalTransform(a, b)
+	if c := pair.AB.Mul4x1(a.Center); !approxVec(c, b.Center) {
+		t.Errorf("A's center goes to %v, want B's %v", c, b.Center)
+	}
+	if n := pair.AB.Mul4x1(a.Normal).Normalize(); !approxVec(n, b.Normal) {
+		t.Errorf("A's normal goes to %v, want B's %v", n, b.Normal)
+	}
+	if v := pair.AB.Mul4x1(a.PlaneV).Normalize(); !approxVec(v, b.PlaneV) {
+		t.Errorf("A's plane vector goes to %v, want B's %v", v, b.PlaneV)
+	}
+	if n := rotate(pair.Rotation, a.Normal); !approxVec(n, b.Normal) {
+		t.Errorf("rotation takes A's normal to %v, want %v", n, b.Normal)
+	}
+	if !approxVec(pair.Scale, glm.Vec4d{0.5, 2.0 / 3, 1, 0}) {
+		t.Errorf("scale %v", pair.Scale)
+	}
+
+	// a portal leaves through the back of its exit's horizon, facing away
+	p := NewPortal(a, b)
+	through := p.Transform.Inv()
+	if c := through.Mul4x1(a.Center); !approxVec(c, b.Center) {
+		t.Errorf("entry center goes to %v, want the exit's %v", c, b.Center)
+	}
+	if n := through.Mul4x1(a.Normal).Normalize(); !approxVec(n, b.Normal.Mul(-1)) {
+		t.Errorf("entry normal goes to %v, want the exit's reversed %v", n, b.Normal.Mul(-1))
+	}
+	if v := through.Mul4x1(a.PlaneV).Normalize(); !approxVec(v, b.PlaneV.Mul(-1)) {
+		t.Errorf("entry plane vector goes to %v, want the exit's reversed %v", v, b.PlaneV.Mul(-1))
+	}
+}
+
+func TestPortalTransformLocal(t *testing.T) {
+	a, b := wallQuad(), floorQuad()
+	pair := PortalTransform(a, b)
+	for _, q := range []struct {
+		quad  Quad
+		local glm.Mat4d
+	}{{a, pair.ALocal}, {b, pair.BLocal}} {
+		if c := q.local.Mul4x1(q.quad.Center); !approxVec(c, glm.Vec4d{0, 0, 0, 1}) {
+			t.Errorf("center is local %v, want the origin", c)
+		}
+		corner := q.quad.Center.Add(q.quad.PlaneV.Mul(q.quad.Scale[0])).Add(q.quad.Up().Mul(q.quad.Scale[1]))
+		if c := q.local.Mul4x1(corner); !approxVec(c, glm.Vec4d{1, 1, 0, 1}) {
+			t.Errorf("corner is local %v, want (1, 1, 0)", c)
+		}
+		p := glm.Vec4d{3, -2, 7, 1}
+		if back := q.quad.Matrix().Mul4x1(q.local.Mul4x1(p)); !approxVec(back, p) {
+			t.Errorf("%v goes to local space and back as %v", p, back)
+		}
+	}
+	// A's local space is B's, so passing through keeps a point's local position
+	p := glm.Vec4d{0.3, -0.4, 0.5, 1}
+	if l := pair.BLocal.Mul4x1(pair.AB.Mul4x1(a.Matrix().Mul4x1(p))); !approxVec(l, p) {
+		t.Errorf("local %v in A is local %v in B", p, l)
+	}
+}
+
+func TestPortalTransformNearParallel(t *testing.T) {
+	// walls a millionth of a radian from parallel, where recovering the angle
+	// from acos of the normals' dot product loses most of its precision
+	tilt := 1e-6
+	if lost := math.Abs(math.Acos(math.Cos(tilt))-tilt) / tilt; lost < 1e-6 {
+		t.Fatalf("acos lost only %v of the angle, the walls are not near enough parallel", lost)
+	}
+	a := Quad{glm.Vec4d{0, 1, 0, 1}, glm.Vec4d{0, 0, 1, 0}, glm.Vec4d{1, 0, 0, 0}, glm.Vec4d{1, 1, 1, 0}, nil}
+	s, c := math.Sincos(tilt)
+	b := Quad{glm.Vec4d{10, 1, 0, 1}, glm.Vec4d{s, 0, c, 0}, glm.Vec4d{c, 0, -s, 0}, glm.Vec4d{1, 1, 1, 0}, nil}
+	pair := PortalTransform(a, b)
+
+	angle := 2 * math.Atan2(pair.Rotation.V.Len(), pair.Rotation.W)
+	if math.Abs(angle-tilt)/tilt > 1e-9 {
+		t.Errorf("rotation of %v between the walls, want %v", angle, tilt)
+	}
+	if n := pair.AB.Mul4x1(a.Normal); n.Sub(b.Normal).Len() > 1e-15 {
+		t.Errorf("A's normal goes to %v, want %v", n, b.Normal)
+	}
+
+	// bodies passing back and forth must not creep
+	p := glm.Vec4d{0.25, 1.5, 0.5, 1}
+	q := p
+	for i := 0; i < 1000; i++ {
+		q = pair.BA.Mul4x1(pair.AB.Mul4x1(q))
+	}
+	if d := q.Sub(p).Len(); d > 1e-11 {
+		t.Errorf("crossing back and forth 1000 times moved a point %v", d)
+	}
+}
@@ -0,0 +1,203 @@
+package portal
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+type Triangle struct {
+	A        glm.Vec4d
+	B        glm.Vec4d
+	C        glm.Vec4d
+	Geometry string
+}
+
+func (t *Triangle) Normal() glm.Vec4d {
+	return Cross3Dv(t.B.Sub(t.A), t.C.Sub(t.A)).Normalize()
+}
+
+func (t *Triangle) Apply(m glm.Mat4d) Triangle {
+	return Triangle{m.Mul4x1(t.A), m.Mul4x1(t.B), m.Mul4x1(t.C), t.Geometry}
+}
+
+// Intersect finds where the ray origin + s*dir meets either face of the
+// triangle, returning s.
+func (t *Triangle) Intersect(origin, dir glm.Vec4d) (float64, bool) {
+	e1 := t.B.Sub(t.A)
+	e2 := t.C.Sub(t.A)
+	p := Cross3Dv(dir, e2)
+	det := e1.Dot(p)
+	if math.Abs(det) < 1e-12 {
+		return 0, false
+	}
+	inv := 1 / det
+	d := origin.Sub(t.A)
+	u := d.Dot(p) * inv
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+	q := Cross3Dv(d, e1)
+	v := dir.Dot(q) * inv
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+	return e2.Dot(q) * inv, true
+}
+
+// Triangles builds world space triangles from a vertex array and TRIANGLES
+// element list, as found in a COLLADA polylist.
+func Triangles(geometry string, vertices []float64, elements []int16, transform glm.Mat4d) []Triangle {
+	vertex := func(i int16) glm.Vec4d {
+		n := int(i) * 3
+		return transform.Mul4x1(glm.Vec4d{vertices[n], vertices[n+1], vertices[n+2], 1})
+	}
+	ts := make([]Triangle, 0, len(elements)/3)
+	for i := 0; i+2 < len(elements); i += 3 {
+		ts = append(ts, Triangle{vertex(elements[i]), vertex(elements[i+1]), vertex(elements[i+2]), geometry})
+	}
+	return ts
+}
+
+// Triangles covers the quad's horizon so it can take part in scene queries.
+func (q *Quad) Triangles(geometry string) []Triangle {
+	vs, _ := q.Mesh()
+	ts := []Triangle{}
+	for mode, elements := range q.Elements() {
+		switch mode {
+		case gl.TRIANGLES:
+			ts = append(ts, Triangles(geometry, vs, elements, glm.Ident4d())...)
+		case gl.TRIANGLE_STRIP:
+			for i := 0; i+2 < len(elements); i++ {
+				ts = append(ts, Triangles(geometry, vs, elements[i:i+3], glm.Ident4d())...)
+			}
+		}
+	}
+	return ts
+}
+
+// Scene is the queryable content of a level: its portals and the static
+// triangles of its geometry.
+type Scene struct {
+	Portals   []Portal
+	Triangles []Triangle
+	Groups    []Group
+	Index     *BVH
+}
+
+// Build indexes the scene's triangles. It is called once the triangles are
+// loaded; until then queries test every triangle.
+func (s *Scene) Build() {
+	s.Index = NewBVH(s.Triangles, s.Groups)
+}
+
+// AddGroup appends the triangles of a model as a group and returns the
+// group's number, by which BVH.Update replaces them. A scene already built is
+// indexed again, as the index no longer covers its triangles.
+func (s *Scene) AddGroup(name string, triangles []Triangle) int {
+	s.Groups = append(s.Groups, Group{name, len(s.Triangles), len(triangles)})
+	s.Triangles = append(s.Triangles, triangles...)
+	if s.Index != nil {
+		s.Build()
+	}
+	return len(s.Groups) - 1
+}
+
+// RayHit ends one segment of a ray cast through the scene.
+type RayHit struct {
+	Point     glm.Vec4d // in the space of the segment
+	Normal    glm.Vec4d // in the space of the segment, facing back along the ray
+	Distance  float64   // along the whole path, in the units of the starting space
+	Portal    int       // the portal the segment entered, or -1
+	Triangle  int       // the triangle the segment stopped on, or -1
+	Transform glm.Mat4d // carries the starting space into the space of the segment
+}
+
+// rayEpsilon keeps a segment from hitting the surface it starts on.
+const rayEpsilon = 1e-9
+
+// portalTolerance lets a portal win against the wall it is placed on.
+const portalTolerance = 1e-4
+
+// Raycast follows a ray through the scene for at most maxDist, continuing out
+// of the linked portal each time it enters one, for at most maxHops portals.
+// Each portal entered adds a hit; the last hit is the scene triangle the ray
+// stopped on, or the portal it could not pass once maxHops was used up.
+func (s *Scene) Raycast(origin, dir glm.Vec4d, maxDist float64, maxHops int) []RayHit {
+	hits := []RayHit{}
+	transform := glm.Ident4d()
+	distance := 0.0
+	scale := 1.0
+	dir = dir.Normalize()
+	for hops := 0; distance < maxDist; hops++ {
+		limit := (maxDist - distance) * scale
+		tri, triT := s.nearestTriangle(origin, dir, limit)
+		port, portT := s.nearestPortal(origin, dir, limit)
+		if port >= 0 && (tri < 0 || portT <= triT+portalTolerance) {
+			p := &s.Portals[port]
+			point := origin.Add(dir.Mul(portT))
+			distance += portT / scale
+			hits = append(hits, RayHit{point, p.EventHorizon.Normal.Mul(-1), distance, port, -1, transform})
+			if hops >= maxHops {
+				break
+			}
+			exit := p.Transform.Inv()
+			transform = exit.Mul4(transform)
+			origin = exit.Mul4x1(point)
+			dir = exit.Mul4x1(dir)
+			length := dir.Len()
+			dir = dir.Mul(1 / length)
+			scale *= length
+		} else if tri >= 0 {
+			point := origin.Add(dir.Mul(triT))
+			distance += triT / scale
+			normal := s.Triangles[tri].Normal()
+			if normal.Dot(dir) > 0 {
+				normal = normal.Mul(-1)
+			}
+			hits = append(hits, RayHit{point, normal, distance, -1, tri, transform})
+			break
+		} else {
+			break
+		}
+	}
+	return hits
+}
+
+func (s *Scene) nearestTriangle(origin, dir glm.Vec4d, limit float64) (int, float64) {
+	if s.Index != nil {
+		hit, ok := s.Index.Ray(origin, dir, rayEpsilon, limit)
+		if !ok {
+			return -1, limit
+		}
+		return hit.Triangle, hit.Distance
+	}
+	nearest, nearestT := -1, limit
+	for i := range s.Triangles {
+		t, ok := s.Triangles[i].Intersect(origin, dir)
+		if ok && t > rayEpsilon && t <= nearestT {
+			nearest, nearestT = i, t
+		}
+	}
+	return nearest, nearestT
+}
+
+// nearestPortal only finds portals entered from the front, the side opposite
+// the normal, matching how the player crosses them.
+func (s *Scene) nearestPortal(origin, dir glm.Vec4d, limit float64) (int, float64) {
+	nearest, nearestT := -1, limit
+	for i := range s.Portals {
+		p := &s.Portals[i]
+		pos := p.Portalview.Mul4x1(origin)
+		v := p.Portalview.Mul4x1(dir)
+		if pos[2] > 0 || v[2] <= 0 {
+			continue
+		}
+		t := -pos[2] / v[2]
+		hit := pos.Add(v.Mul(t))
+		if t > rayEpsilon && t <= nearestT && p.EventHorizon.Contains(hit[0], hit[1]) {
+			nearest, nearestT = i, t
+		}
+	}
+	return nearest, nearestT
+}
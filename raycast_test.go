@@ -0,0 +1,114 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+// wall is a square of two triangles at depth z, centered on (x, 0).
+func wall(x, z, half float64) []Triangle {
+	a := glm.Vec4d{x - half, -half, z, 1}
+	b := glm.Vec4d{x + half, -half, z, 1}
+	c := glm.Vec4d{x + half, half, z, 1}
+	d := glm.Vec4d{x - half, half, z, 1}
+	return []Triangle{{a, b, c, "wall"}, {a, c, d, "wall"}}
+}
+
+// facing places a horizon at (x, 0, z) entered by rays heading along dir.
+func facing(t testing.TB, x, z float64, dir glm.Vec4d) Quad {
+	q, err := Orient(glm.Vec4d{x, 0, z, 1}, dir.Mul(-1), glm.Vec4d{0, 1, 0, 0}, glm.Vec2d{1, 1}, Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// chainedScene sends a ray from the origin down -z through 1, out of 2 at
+// x=10 heading +z, through 3, out of 4 at x=20 heading +z onto a wall at z=3.
+func chainedScene(t testing.TB) *Scene {
+	forward := glm.Vec4d{0, 0, 1, 0}
+	back := glm.Vec4d{0, 0, -1, 0}
+	n := NewNetwork()
+	n.Horizons[1] = facing(t, 0, -5, back)
+	n.Horizons[2] = facing(t, 10, -5, back)
+	n.Horizons[3] = facing(t, 10, 5, forward)
+	n.Horizons[4] = facing(t, 20, 0, back)
+	n.Link(1, 2)
+	n.Link(3, 4)
+	portals, _ := n.Portals()
+	return &Scene{Portals: portals, Triangles: wall(20, 3, 5)}
+}
+
+func TestRaycastChainedPortals(t *testing.T) {
+	s := chainedScene(t)
+	origin := glm.Vec4d{0, 0, 0, 1}
+	dir := glm.Vec4d{0, 0, -1, 0}
+	hits := s.Raycast(origin, dir, 100, 4)
+	if len(hits) != 3 {
+		t.Fatalf("got %d hits, want two portals and the wall: %v", len(hits), hits)
+	}
+	if hits[0].Portal != 0 || hits[1].Portal != 2 || hits[2].Portal != -1 {
+		t.Errorf("hit portals %d, %d, %d, want 0, 2, -1", hits[0].Portal, hits[1].Portal, hits[2].Portal)
+	}
+	last := hits[2]
+	if last.Triangle < 0 {
+		t.Fatalf("ray did not stop on the wall")
+	}
+	want := glm.Vec4d{20, 0, 3, 1}
+	if last.Point.Sub(want).Len() > 4*SurfaceOffset {
+		t.Errorf("ray stopped at %v, want %v", last.Point, want)
+	}
+	// 5 to the first portal, 10 from the second to the third, 3 to the wall
+	if math.Abs(last.Distance-18) > 4*SurfaceOffset {
+		t.Errorf("distance %v, want 18", last.Distance)
+	}
+	if !approxVec(last.Normal, glm.Vec4d{0, 0, -1, 0}) {
+		t.Errorf("wall normal %v faces along the ray", last.Normal)
+	}
+	// both links only translate, 1 to 2 by (10, 0, 0) and 3 to 4 by about
+	// (10, 0, -5) once the horizons are lifted off their surfaces
+	p := last.Transform.Mul4x1(hits[0].Point)
+	if want := hits[0].Point.Add(glm.Vec4d{20, 0, -5, 0}); p.Sub(want).Len() > 4*SurfaceOffset {
+		t.Errorf("final transform takes %v to %v, want %v", hits[0].Point, p, want)
+	}
+}
+
+func TestRaycastMaxHops(t *testing.T) {
+	s := chainedScene(t)
+	origin := glm.Vec4d{0, 0, 0, 1}
+	dir := glm.Vec4d{0, 0, -1, 0}
+	for hops := 0; hops < 2; hops++ {
+		hits := s.Raycast(origin, dir, 100, hops)
+		if len(hits) != hops+1 {
+			t.Fatalf("maxHops %d: got %d hits, want %d", hops, len(hits), hops+1)
+		}
+		if hits[hops].Portal < 0 || hits[hops].Triangle >= 0 {
+			t.Errorf("maxHops %d: last hit %v should be the portal it could not pass", hops, hits[hops])
+		}
+	}
+}
+
+func TestRaycastMaxDistance(t *testing.T) {
+	s := chainedScene(t)
+	hits := s.Raycast(glm.Vec4d{0, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 10, 4)
+	if len(hits) != 1 || hits[0].Portal != 0 {
+		t.Errorf("ray running out after 10 hit %v, want only the first portal", hits)
+	}
+}
+
+func TestRaycastIndexAgrees(t *testing.T) {
+	s := chainedScene(t)
+	linear := s.Raycast(glm.Vec4d{0, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 100, 4)
+	s.AddGroup("wall", nil)
+	s.Build()
+	indexed := s.Raycast(glm.Vec4d{0, 0, 0, 1}, glm.Vec4d{0, 0, -1, 0}, 100, 4)
+	if len(linear) != len(indexed) {
+		t.Fatalf("linear scan found %d hits, the index %d", len(linear), len(indexed))
+	}
+	for i := range linear {
+		if linear[i].Triangle != indexed[i].Triangle || math.Abs(linear[i].Distance-indexed[i].Distance) > 1e-9 {
+			t.Errorf("hit %d: linear %v, indexed %v", i, linear[i], indexed[i])
+		}
+	}
+}
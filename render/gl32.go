@@ -0,0 +1,346 @@
+package render
+
+import (
+	"fmt"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"reflect"
+)
+
+// GL32 renders through OpenGL 3.2 with programs from a gtk.ShaderLibrary.
+type GL32 struct {
+	Shaders gtk.ShaderLibrary
+	Vao     gl.VertexArrayObject
+	Frame   gl.Texture
+	Width   int
+	Height  int
+	// OnError is told of textures which cannot be loaded, and of uniforms
+	// set on a program which does not bind them.
+	OnError func(error)
+
+	programs map[string]programLocations
+	current  programLocations
+	surfaces map[*gtk.Geometry]surface
+	textures map[string]gl.Texture // loaded by image file
+	unknown  map[string]bool       // uniforms already reported, by program and name
+}
+
+type programLocations struct {
+	name       string
+	uniforms   map[string]gl.UniformLocation
+	attributes map[string]gl.AttributeLocation // besides position
+	position   gl.AttributeLocation
+}
+
+// surface is what a geometry made by NewSurface adds to gtk.Geometry.
+type surface struct {
+	normals   gl.Buffer
+	texcoords gl.Buffer
+	material  *Material
+	texture   gl.Texture
+	textured  bool
+}
+
+func NewGL32(shaders gtk.ShaderLibrary, vao gl.VertexArrayObject, frame gl.Texture) *GL32 {
+	return &GL32{shaders, vao, frame, 0, 0, nil, map[string]programLocations{}, programLocations{}, map[*gtk.Geometry]surface{}, map[string]gl.Texture{}, map[string]bool{}}
+}
+
+// BindProgram takes the uniform and attribute locations of a program from a
+// struct filled in by gtk.ShaderLibrary.BindProgramLocations, keyed by their
+// gl tags.
+func (g *GL32) BindProgram(program string, bindings interface{}) {
+	locations := programLocations{name: program, uniforms: map[string]gl.UniformLocation{}, attributes: map[string]gl.AttributeLocation{}}
+	v := reflect.ValueOf(bindings).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("gl")
+		switch loc := v.Field(i).Interface().(type) {
+		case gl.UniformLocation:
+			locations.uniforms[name] = loc
+		case gl.AttributeLocation:
+			if name == "position" {
+				locations.position = loc
+			} else {
+				locations.attributes[name] = loc
+			}
+		}
+	}
+	g.programs[program] = locations
+}
+
+func (g *GL32) NewGeometry(name string, vertices, normals []float64, elements map[gl.Enum][]int16) *gtk.Geometry {
+	return g.NewSurface(name, vertices, normals, nil, elements, nil)
+}
+
+// NewSurface loads the material's texture the first time it is used. A
+// texture which cannot be read leaves the surface untextured, as Software
+// does, and is passed to OnError.
+func (g *GL32) NewSurface(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *Material) *gtk.Geometry {
+	geo := gtk.NewGeometry(name, vertices, normals, gtk.MakeElements(elements))
+	s := surface{newBuffer(normals), newBuffer(texcoords), material, 0, false}
+	if material != nil && material.Texture != "" && len(texcoords) > 0 {
+		t, err := g.texture(material.Texture)
+		if err != nil && g.OnError != nil {
+			g.OnError(fmt.Errorf("%s: %v", name, err))
+		}
+		s.texture, s.textured = t, err == nil
+	}
+	g.surfaces[geo] = s
+	return geo
+}
+
+// newBuffer uploads data as floats, or makes no buffer for no data.
+func newBuffer(data []float64) gl.Buffer {
+	if len(data) == 0 {
+		return 0
+	}
+	fs := make([]float32, len(data))
+	for i, v := range data {
+		fs[i] = float32(v)
+	}
+	var b gl.Buffer
+	gl.GenBuffers(1, &b)
+	gl.BindBuffer(gl.ARRAY_BUFFER, b)
+	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(4*len(fs)), gl.Pointer(&fs[0]), gl.STATIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gtk.PanicOnError()
+	return b
+}
+
+func (g *GL32) texture(filename string) (gl.Texture, error) {
+	if t, ok := g.textures[filename]; ok {
+		return t, nil
+	}
+	var t gl.Texture
+	gl.GenTextures(1, &t)
+	err := gtk.LoadTexture(t, filename)
+	if err != nil {
+		gl.DeleteTextures(1, &t)
+		return 0, err
+	}
+	gl.BindTexture(gl.TEXTURE_2D, t)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.Int(gl.REPEAT))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.Int(gl.REPEAT))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gtk.PanicOnError()
+	g.textures[filename] = t
+	return t, nil
+}
+
+func (g *GL32) Resize(width, height int) {
+	g.Width, g.Height = width, height
+}
+
+func (g *GL32) Clear(color gtk.Color) {
+	gl.ClearColor(color[0], color[1], color[2], color[3])
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT | gl.STENCIL_BUFFER_BIT)
+}
+
+func (g *GL32) UseProgram(program string) {
+	g.Shaders.UseProgram(program)
+	g.current = g.programs[program]
+}
+
+// uniform finds one of the current program's uniforms. Names the program does
+// not bind are skipped, and passed to OnError the first time they are set.
+func (g *GL32) uniform(name string) (gl.UniformLocation, bool) {
+	loc, ok := g.current.uniforms[name]
+	key := g.current.name + "." + name
+	if !ok && !g.unknown[key] {
+		g.unknown[key] = true
+		if g.OnError != nil {
+			g.OnError(fmt.Errorf("program %q has no uniform %q", g.current.name, name))
+		}
+	}
+	return loc, ok
+}
+
+func (g *GL32) UniformMatrix(name string, m glm.Mat4d) {
+	if loc, ok := g.uniform(name); ok {
+		gl.UniformMatrix4fv(loc, 1, gl.FALSE, gtk.MatArray(m))
+	}
+}
+
+func (g *GL32) UniformFloat(name string, v float64) {
+	if loc, ok := g.uniform(name); ok {
+		gl.Uniform1f(loc, gl.Float(v))
+	}
+}
+
+func (g *GL32) UniformVec2(name string, v glm.Vec2d) {
+	if loc, ok := g.uniform(name); ok {
+		gl.Uniform2f(loc, gl.Float(v[0]), gl.Float(v[1]))
+	}
+}
+
+func (g *GL32) UniformColor(name string, c gtk.Color) {
+	if loc, ok := g.uniform(name); ok {
+		gl.Uniform4fv(loc, 1, &c[0])
+	}
+}
+
+func (g *GL32) UniformFrame(name string) {
+	if loc, ok := g.uniform(name); ok {
+		gtk.AttachTexture(loc, gl.TEXTURE0, gl.TEXTURE_2D, g.Frame)
+	}
+}
+
+func (g *GL32) DrawGeometry(geo *gtk.Geometry, lines bool) {
+	s := g.surfaces[geo]
+	vertexAttribute := g.current.position
+	gl.BindBuffer(gl.ARRAY_BUFFER, geo.VertexBuffer)
+	gl.BindVertexArray(g.Vao)
+	gl.VertexAttribPointer(vertexAttribute, 3, gl.FLOAT, gl.FALSE, 12, nil)
+	gl.EnableVertexAttribArray(vertexAttribute)
+	normal, hasNormal := g.attribute("normal", s.normals, 3)
+	texcoord, hasTexcoord := g.attribute("texcoord", s.texcoords, 2)
+	g.bindMaterial(s)
+
+	for _, elem := range geo.Elements {
+		if lines == (elem.DrawType == gl.LINES) {
+			gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, elem.Buffer)
+			gl.DrawElements(elem.DrawType, gl.Sizei(elem.Count), gl.UNSIGNED_SHORT, nil)
+			gtk.PanicOnError()
+		}
+	}
+	gl.DisableVertexAttribArray(vertexAttribute)
+	if hasNormal {
+		gl.DisableVertexAttribArray(normal)
+	}
+	if hasTexcoord {
+		gl.DisableVertexAttribArray(texcoord)
+	}
+}
+
+// attribute feeds a buffer of the given components per vertex to the
+// current program's attribute, if both exist. It is false when there is
+// nothing to disable after drawing; the attribute then reads as zero.
+func (g *GL32) attribute(name string, buffer gl.Buffer, size int) (gl.AttributeLocation, bool) {
+	loc, ok := g.current.attributes[name]
+	if !ok {
+		return 0, false
+	}
+	if buffer == 0 {
+		gl.VertexAttrib3f(loc, 0, 0, 0)
+		return loc, false
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
+	gl.VertexAttribPointer(loc, gl.Int(size), gl.FLOAT, gl.FALSE, gl.Sizei(4*size), nil)
+	gl.EnableVertexAttribArray(loc)
+	return loc, true
+}
+
+// bindMaterial sets the material uniforms the current program has. The
+// diffuse texture takes the second texture unit, leaving the first to the
+// captured frame.
+func (g *GL32) bindMaterial(s surface) {
+	u := g.current.uniforms
+	set := func(name string, v float64) {
+		if loc, ok := u[name]; ok {
+			gl.Uniform1f(loc, gl.Float(v))
+		}
+	}
+	if s.material == nil {
+		set("material", 0)
+		set("textured", 0)
+		return
+	}
+	set("material", 1)
+	if loc, ok := u["diffuse"]; ok {
+		gl.Uniform4fv(loc, 1, &s.material.Diffuse[0])
+	}
+	if !s.textured {
+		set("textured", 0)
+		return
+	}
+	set("textured", 1)
+	if loc, ok := u["diffuseTexture"]; ok {
+		gtk.AttachTexture(loc, gl.TEXTURE1, gl.TEXTURE_2D, s.texture)
+	}
+}
+
+func (g *GL32) Stencil() Stencil {
+	return glStencil{}
+}
+
+func (g *GL32) Cull(enabled bool) {
+	if enabled {
+		gl.Enable(gl.CULL_FACE)
+	} else {
+		gl.Disable(gl.CULL_FACE)
+	}
+}
+
+func (g *GL32) Scissor(rect portal.Rect) {
+	x0 := math.Floor((rect.Min[0] + 1) / 2 * float64(g.Width))
+	y0 := math.Floor((rect.Min[1] + 1) / 2 * float64(g.Height))
+	x1 := math.Ceil((rect.Max[0] + 1) / 2 * float64(g.Width))
+	y1 := math.Ceil((rect.Max[1] + 1) / 2 * float64(g.Height))
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Scissor(gl.Int(x0), gl.Int(y0), gl.Sizei(x1-x0), gl.Sizei(y1-y0))
+}
+
+func (g *GL32) NoScissor() {
+	gl.Disable(gl.SCISSOR_TEST)
+}
+
+func (g *GL32) CaptureFrame() {
+	gl.BindTexture(gl.TEXTURE_2D, g.Frame)
+	gl.CopyTexImage2D(gl.TEXTURE_2D, 0, gl.RGB, 0, 0, gl.Sizei(g.Width), gl.Sizei(g.Height), 0)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.Int(gl.LINEAR))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.Int(gl.LINEAR))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.Int(gl.CLAMP_TO_EDGE))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.Int(gl.CLAMP_TO_EDGE))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gtk.PanicOnError()
+}
+
+func (g *GL32) StencilBits() int {
+	var bits gl.Int
+	gl.GetFramebufferAttachmentParameteriv(gl.DRAW_FRAMEBUFFER, gl.STENCIL, gl.FRAMEBUFFER_ATTACHMENT_STENCIL_SIZE, &bits)
+	gtk.PanicOnError()
+	return int(bits)
+}
+
+func (g *GL32) Snapshot() *Snapshot {
+	w, h := g.Width, g.Height
+	n := w * h
+	rgba := make([]float32, 4*n)
+	depth := make([]float32, n)
+	stencil := make([]uint8, n)
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	if n > 0 {
+		gl.ReadPixels(0, 0, gl.Sizei(w), gl.Sizei(h), gl.RGBA, gl.FLOAT, gl.Pointer(&rgba[0]))
+		gl.ReadPixels(0, 0, gl.Sizei(w), gl.Sizei(h), gl.DEPTH_COMPONENT, gl.FLOAT, gl.Pointer(&depth[0]))
+		gl.ReadPixels(0, 0, gl.Sizei(w), gl.Sizei(h), gl.STENCIL_INDEX, gl.UNSIGNED_BYTE, gl.Pointer(&stencil[0]))
+	}
+	gtk.PanicOnError()
+	s := &Snapshot{NewImage(w, h), make([]float64, n), stencil}
+	for i := 0; i < n; i++ {
+		c := rgba[4*i : 4*i+4]
+		s.Color.Pixels[i] = glm.Vec4d{float64(c[0]), float64(c[1]), float64(c[2]), float64(c[3])}
+		s.Depth[i] = float64(depth[i])
+	}
+	return s
+}
+
+// glStencil forwards to gtk.Stencil.
+type glStencil struct{}
+
+func (s glStencil) Enable() Stencil        { gtk.Stencil.Enable(); return s }
+func (s glStencil) Disable() Stencil       { gtk.Stencil.Disable(); return s }
+func (s glStencil) Mask(level int) Stencil { gtk.Stencil.Mask(level); return s }
+func (s glStencil) Draw() Stencil          { gtk.Stencil.Draw(); return s }
+func (s glStencil) NoDraw() Stencil        { gtk.Stencil.NoDraw(); return s }
+func (s glStencil) Depth() Stencil         { gtk.Stencil.Depth(); return s }
+func (s glStencil) NoDepth() Stencil       { gtk.Stencil.NoDepth(); return s }
+func (s glStencil) NoDepthMask() Stencil   { gtk.Stencil.NoDepthMask(); return s }
+func (s glStencil) DepthLE() Stencil       { gtk.Stencil.DepthLE(); return s }
+func (s glStencil) DepthAlways() Stencil   { gtk.Stencil.DepthAlways(); return s }
+func (s glStencil) Increment() Stencil     { gtk.Stencil.Increment(); return s }
+func (s glStencil) Decrement() Stencil     { gtk.Stencil.Decrement(); return s }
+func (s glStencil) Keep() Stencil          { gtk.Stencil.Keep(); return s }
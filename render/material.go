@@ -0,0 +1,43 @@
+package render
+
+import (
+	gtk "github.com/GlenKelley/go-glutil"
+	glm "github.com/Jragonmiris/mathgl"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+// Material is how a surface is shaded: a diffuse color, multiplied by a
+// diffuse texture where the surface has texture coordinates.
+type Material struct {
+	Name    string
+	Diffuse gtk.Color
+	Texture string // image file of the diffuse texture, if any
+}
+
+// DefaultDiffuse is the diffuse color of materials which don't give one.
+var DefaultDiffuse = gtk.Color{0.8, 0.8, 0.8, 1}
+
+// LoadImage decodes an image file into an Image, bottom row first.
+func LoadImage(filename string) (*Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	m := NewImage(b.Dx(), b.Dy())
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			r, g, bl, a := src.At(b.Min.X+x, b.Max.Y-1-y).RGBA()
+			m.Pixels[y*m.Width+x] = glm.Vec4d{float64(r) / 0xffff, float64(g) / 0xffff, float64(bl) / 0xffff, float64(a) / 0xffff}
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,161 @@
+package render
+
+import (
+	"fmt"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"strings"
+)
+
+// Command is one call made on a Recorder.
+type Command struct {
+	Op    string      // the call, such as "draw" or "stencil.increment"
+	Name  string      // the geometry, program or uniform involved
+	Value interface{} // the value set, if any
+}
+
+func (c Command) String() string {
+	s := c.Op
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	if c.Value != nil {
+		s += fmt.Sprint(" ", c.Value)
+	}
+	return s
+}
+
+// Recorder is a Renderer which draws nothing and records every call in order.
+type Recorder struct {
+	Commands []Command
+	Bits     int
+	names    map[*gtk.Geometry]string
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{[]Command{}, 8, map[*gtk.Geometry]string{}}
+}
+
+func (r *Recorder) record(op, name string, value interface{}) {
+	r.Commands = append(r.Commands, Command{op, name, value})
+}
+
+// Filter lists the commands whose op starts with prefix.
+func (r *Recorder) Filter(prefix string) []Command {
+	cs := []Command{}
+	for _, c := range r.Commands {
+		if strings.HasPrefix(c.Op, prefix) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func (r *Recorder) Reset() {
+	r.Commands = []Command{}
+}
+
+func (r *Recorder) NewGeometry(name string, vertices, normals []float64, elements map[gl.Enum][]int16) *gtk.Geometry {
+	return r.NewSurface(name, vertices, normals, nil, elements, nil)
+}
+
+func (r *Recorder) NewSurface(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *Material) *gtk.Geometry {
+	geo := &gtk.Geometry{}
+	r.names[geo] = name
+	return geo
+}
+
+func (r *Recorder) Resize(width, height int) {
+	r.record("resize", "", [2]int{width, height})
+}
+
+func (r *Recorder) Clear(color gtk.Color) {
+	r.record("clear", "", color)
+}
+
+func (r *Recorder) UseProgram(program string) {
+	r.record("program", program, nil)
+}
+
+func (r *Recorder) UniformMatrix(name string, m glm.Mat4d) {
+	r.record("uniform", name, m)
+}
+
+func (r *Recorder) UniformFloat(name string, v float64) {
+	r.record("uniform", name, v)
+}
+
+func (r *Recorder) UniformVec2(name string, v glm.Vec2d) {
+	r.record("uniform", name, v)
+}
+
+func (r *Recorder) UniformColor(name string, c gtk.Color) {
+	r.record("uniform", name, c)
+}
+
+func (r *Recorder) UniformFrame(name string) {
+	r.record("uniform.frame", name, nil)
+}
+
+func (r *Recorder) DrawGeometry(geo *gtk.Geometry, lines bool) {
+	if lines {
+		r.record("draw.lines", r.names[geo], nil)
+	} else {
+		r.record("draw", r.names[geo], nil)
+	}
+}
+
+func (r *Recorder) Stencil() Stencil {
+	return recordedStencil{r}
+}
+
+func (r *Recorder) Cull(enabled bool) {
+	r.record("cull", "", enabled)
+}
+
+func (r *Recorder) Scissor(rect portal.Rect) {
+	r.record("scissor", "", rect)
+}
+
+func (r *Recorder) NoScissor() {
+	r.record("scissor.off", "", nil)
+}
+
+func (r *Recorder) CaptureFrame() {
+	r.record("capture", "", nil)
+}
+
+func (r *Recorder) StencilBits() int {
+	return r.Bits
+}
+
+// Snapshot records the read back and returns empty buffers.
+func (r *Recorder) Snapshot() *Snapshot {
+	r.record("snapshot", "", nil)
+	return &Snapshot{NewImage(0, 0), []float64{}, []uint8{}}
+}
+
+type recordedStencil struct {
+	r *Recorder
+}
+
+func (s recordedStencil) op(name string, value interface{}) Stencil {
+	s.r.record("stencil."+name, "", value)
+	return s
+}
+
+func (s recordedStencil) Enable() Stencil        { return s.op("enable", nil) }
+func (s recordedStencil) Disable() Stencil       { return s.op("disable", nil) }
+func (s recordedStencil) Mask(level int) Stencil { return s.op("mask", level) }
+func (s recordedStencil) Draw() Stencil          { return s.op("draw", nil) }
+func (s recordedStencil) NoDraw() Stencil        { return s.op("nodraw", nil) }
+func (s recordedStencil) Depth() Stencil         { return s.op("depth", nil) }
+func (s recordedStencil) NoDepth() Stencil       { return s.op("nodepth", nil) }
+func (s recordedStencil) NoDepthMask() Stencil   { return s.op("nodepthmask", nil) }
+func (s recordedStencil) DepthLE() Stencil       { return s.op("depthle", nil) }
+func (s recordedStencil) DepthAlways() Stencil   { return s.op("depthalways", nil) }
+func (s recordedStencil) Increment() Stencil     { return s.op("increment", nil) }
+func (s recordedStencil) Decrement() Stencil     { return s.op("decrement", nil) }
+func (s recordedStencil) Keep() Stencil          { return s.op("keep", nil) }
@@ -0,0 +1,57 @@
+package render
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"reflect"
+	"testing"
+)
+
+var _ Renderer = NewRecorder()
+
+func TestRecorderCommands(t *testing.T) {
+	r := NewRecorder()
+	wall := r.NewGeometry("wall", []float64{0, 0, 0}, nil, portal.Rectangle{}.Elements())
+	r.UseProgram("scene")
+	r.UniformFloat("glow", 0.5)
+	r.Stencil().Enable().Mask(2).Increment()
+	r.DrawGeometry(wall, false)
+	r.DrawGeometry(wall, true)
+	want := []string{
+		"program scene",
+		"uniform glow 0.5",
+		"stencil.enable",
+		"stencil.mask 2",
+		"stencil.increment",
+		"draw wall",
+		"draw.lines wall",
+	}
+	got := []string{}
+	for _, c := range r.Commands {
+		got = append(got, c.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded %q, want %q", got, want)
+	}
+	if stencil := r.Filter("stencil."); len(stencil) != 3 || stencil[1].Value != 2 {
+		t.Errorf("stencil commands %v", stencil)
+	}
+	r.Reset()
+	if len(r.Commands) != 0 {
+		t.Errorf("%d commands left after a reset", len(r.Commands))
+	}
+}
+
+func TestRecorderSurfaceNames(t *testing.T) {
+	r := NewRecorder()
+	a := r.NewSurface("a", nil, nil, nil, map[gl.Enum][]int16{}, &Material{Name: "stone"})
+	b := r.NewGeometry("b", nil, nil, map[gl.Enum][]int16{})
+	r.DrawGeometry(b, false)
+	r.DrawGeometry(a, false)
+	r.DrawGeometry(&gtk.Geometry{}, false)
+	draws := r.Filter("draw")
+	if len(draws) != 3 || draws[0].Name != "b" || draws[1].Name != "a" || draws[2].Name != "" {
+		t.Errorf("draws %v, want b, a and an unnamed geometry", draws)
+	}
+}
@@ -0,0 +1,64 @@
+// Package render puts the draw calls and GL state used to render portal scenes
+// behind an interface, so the rendering logic can run without a GPU.
+package render
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+)
+
+type Renderer interface {
+	NewGeometry(name string, vertices, normals []float64, elements map[gl.Enum][]int16) *gtk.Geometry
+	// NewSurface is NewGeometry with texture coordinates, two per vertex, and
+	// a material. Surfaces without a material are drawn as plain geometry.
+	NewSurface(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *Material) *gtk.Geometry
+	Resize(width, height int)
+	// Clear resets the color, depth and stencil buffers and enables depth testing.
+	Clear(color gtk.Color)
+
+	UseProgram(program string)
+	// Uniforms are set on the current program, by their name in the shader.
+	UniformMatrix(name string, m glm.Mat4d)
+	UniformFloat(name string, v float64)
+	UniformVec2(name string, v glm.Vec2d)
+	UniformColor(name string, c gtk.Color)
+	// UniformFrame binds the last captured frame to a sampler.
+	UniformFrame(name string)
+
+	// DrawGeometry draws the line elements of geo if lines is set, and the
+	// others if not, feeding its vertices to the current program's position,
+	// normal and texcoord. A surface's material sets the material, diffuse,
+	// textured and diffuseTexture uniforms where the program has them.
+	DrawGeometry(geo *gtk.Geometry, lines bool)
+
+	Stencil() Stencil
+	Cull(enabled bool)
+	Scissor(rect portal.Rect)
+	NoScissor()
+
+	// CaptureFrame keeps a copy of the color buffer.
+	CaptureFrame()
+	StencilBits() int
+	// Snapshot reads back the color, depth and stencil buffers.
+	Snapshot() *Snapshot
+}
+
+// Stencil mirrors the chained stencil and depth state of gtk.Stencil.
+type Stencil interface {
+	Enable() Stencil
+	Disable() Stencil
+	// Mask limits drawing to where the stencil holds level.
+	Mask(level int) Stencil
+	Draw() Stencil
+	NoDraw() Stencil
+	Depth() Stencil
+	NoDepth() Stencil
+	NoDepthMask() Stencil
+	DepthLE() Stencil
+	DepthAlways() Stencil
+	Increment() Stencil
+	Decrement() Stencil
+	Keep() Stencil
+}
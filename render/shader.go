@@ -0,0 +1,179 @@
+package render
+
+import (
+	gtk "github.com/GlenKelley/go-glutil"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Vertex is the output of a software vertex shader.
+type Vertex struct {
+	Position glm.Vec4d // clip space
+	Clip     []float64 // clip distances; the primitive is kept where all are >= 0
+	Varying  []float64 // interpolated for the fragment shader
+}
+
+// Attributes are the inputs of a software vertex shader. Geometry without
+// normals or texture coordinates leaves them zero.
+type Attributes struct {
+	Position glm.Vec4d
+	Normal   glm.Vec4d
+	Texcoord glm.Vec2d
+}
+
+// Shader is a GLSL program rewritten in Go for the Software renderer.
+type Shader interface {
+	Vertex(u *Uniforms, a Attributes) Vertex
+	// Fragment returns the color and depth of a fragment, given its
+	// interpolated varyings and window space depth.
+	Fragment(u *Uniforms, varying []float64, depth float64) (glm.Vec4d, float64)
+}
+
+// Uniforms holds the uniform values set on one program.
+type Uniforms struct {
+	Values   map[string]interface{}
+	textures map[string]*Image // images bound to samplers
+}
+
+func newUniforms() *Uniforms {
+	return &Uniforms{map[string]interface{}{}, map[string]*Image{}}
+}
+
+func (u *Uniforms) Mat4(name string) glm.Mat4d {
+	if m, ok := u.Values[name].(glm.Mat4d); ok {
+		return m
+	}
+	return glm.Ident4d()
+}
+
+func (u *Uniforms) Float(name string) float64 {
+	f, _ := u.Values[name].(float64)
+	return f
+}
+
+func (u *Uniforms) Vec2(name string) glm.Vec2d {
+	v, _ := u.Values[name].(glm.Vec2d)
+	return v
+}
+
+func (u *Uniforms) Color(name string) glm.Vec4d {
+	c, _ := u.Values[name].(gtk.Color)
+	return glm.Vec4d{float64(c[0]), float64(c[1]), float64(c[2]), float64(c[3])}
+}
+
+// Sample reads the image bound to name at texture coordinates s, t, clamped
+// to its edges.
+func (u *Uniforms) Sample(name string, s, t float64) glm.Vec4d {
+	m := u.textures[name]
+	if m == nil || m.Width == 0 || m.Height == 0 {
+		return glm.Vec4d{0, 0, 0, 1}
+	}
+	x := clampInt(int(s*float64(m.Width)), 0, m.Width-1)
+	y := clampInt(int(t*float64(m.Height)), 0, m.Height-1)
+	return m.Pixels[y*m.Width+x]
+}
+
+// SampleRepeat is Sample with the image repeating beyond its edges.
+func (u *Uniforms) SampleRepeat(name string, s, t float64) glm.Vec4d {
+	return u.Sample(name, s-math.Floor(s), t-math.Floor(t))
+}
+
+func clampInt(x, min, max int) int {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+func clamp(x, min, max float64) float64 {
+	return math.Max(min, math.Min(max, x))
+}
+
+func mix(a, b glm.Vec4d, t float64) glm.Vec4d {
+	return a.Mul(1 - t).Add(b.Mul(t))
+}
+
+// sceneLight is the direction lit surfaces face toward, and sceneAmbient the
+// light reaching surfaces facing away from it.
+var sceneLight = glm.Vec3d{0.3, 1, 0.5}.Normalize()
+
+const sceneAmbient = 0.35
+
+// SceneShader follows scene.v.glsl and scene.f.glsl. A portalview uniform, if
+// set, clips away everything behind that portal's horizon.
+type SceneShader struct{}
+
+func (SceneShader) Vertex(u *Uniforms, a Attributes) Vertex {
+	worldview := u.Mat4("worldview")
+	world := worldview.Mul4x1(a.Position)
+	inception := u.Mat4("inception").Mul4x1(world)
+	// the inverse transpose keeps normals across unevenly scaled surfaces, as
+	// transpose(inverse(mat3(worldview))) does for scene.v.glsl
+	normal := worldview.Inv().Transpose().Mul4x1(glm.Vec4d{a.Normal[0], a.Normal[1], a.Normal[2], 0})
+	clip := u.Mat4("projection").Mul4(u.Mat4("cameraview")).Mul4x1(world)
+	var distances []float64
+	if _, ok := u.Values["portalview"]; ok {
+		distances = []float64{u.Mat4("portalview").Mul4x1(world)[2]}
+	}
+	return Vertex{clip, distances, []float64{
+		inception[0], inception[1], inception[2],
+		normal[0], normal[1], normal[2],
+		a.Texcoord[0], a.Texcoord[1],
+	}}
+}
+
+func (SceneShader) Fragment(u *Uniforms, varying []float64, depth float64) (glm.Vec4d, float64) {
+	v := glm.Vec4d{
+		clamp(math.Sin(0.1*varying[0])+0.5, 0, 1),
+		clamp(math.Sin(0.5*varying[1]), 0, 1),
+		clamp(math.Sin(0.1*varying[2])+0.5, 0, 1),
+		1,
+	}
+	if u.Float("material") > 0 {
+		v = u.Color("diffuse")
+		if u.Float("textured") > 0 {
+			t := u.SampleRepeat("diffuseTexture", varying[6], varying[7])
+			v = glm.Vec4d{v[0] * t[0], v[1] * t[1], v[2] * t[2], v[3] * t[3]}
+		}
+		normal := glm.Vec3d{varying[3], varying[4], varying[5]}
+		if normal.Len() > 1e-9 {
+			light := sceneAmbient + (1-sceneAmbient)*math.Max(0, normal.Normalize().Dot(sceneLight))
+			v = glm.Vec4d{v[0] * light, v[1] * light, v[2] * light, v[3]}
+		}
+	}
+	glow := u.Float("glow")
+	return mix(v, glm.Vec4d{0, 1, 0, 1}, glow), depth * (1 - glow)
+}
+
+// FillShader follows fill.v.glsl and fill.f.glsl.
+type FillShader struct{}
+
+func (FillShader) Vertex(u *Uniforms, a Attributes) Vertex {
+	return Vertex{a.Position, nil, nil}
+}
+
+func (FillShader) Fragment(u *Uniforms, varying []float64, depth float64) (glm.Vec4d, float64) {
+	return u.Color("color"), clamp(u.Float("depth"), 0, 1)
+}
+
+// FallbackShader follows fallback.v.glsl and fallback.f.glsl.
+type FallbackShader struct{}
+
+func (FallbackShader) Vertex(u *Uniforms, a Attributes) Vertex {
+	p := a.Position
+	return Vertex{p, nil, []float64{p[0]*0.5 + 0.5, p[1]*0.5 + 0.5}}
+}
+
+func (FallbackShader) Fragment(u *Uniforms, varying []float64, depth float64) (glm.Vec4d, float64) {
+	texel := u.Vec2("texel").Mul(u.Float("blur"))
+	sum := glm.Vec4d{}
+	for x := -2; x <= 2; x++ {
+		for y := -2; y <= 2; y++ {
+			sum = sum.Add(u.Sample("frame", varying[0]+float64(x)*texel[0], varying[1]+float64(y)*texel[1]))
+		}
+	}
+	return sum.Mul(1.0 / 25), clamp(u.Float("depth"), 0, 1)
+}
@@ -0,0 +1,105 @@
+package render
+
+import (
+	"fmt"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot is a copy of the color, depth and stencil buffers, rows bottom up.
+type Snapshot struct {
+	Color   *Image
+	Depth   []float64
+	Stencil []uint8
+}
+
+// stencilPalette colors stencil levels, so nested portal views stand apart.
+var stencilPalette = []glm.Vec4d{
+	{0, 0, 0, 1},
+	{0.9, 0.1, 0.1, 1},
+	{0.1, 0.8, 0.1, 1},
+	{0.1, 0.3, 0.9, 1},
+	{0.9, 0.8, 0.1, 1},
+	{0.8, 0.1, 0.8, 1},
+	{0.1, 0.8, 0.8, 1},
+	{1, 1, 1, 1},
+}
+
+// StencilImage shows each stencil level in its own color, repeating after
+// the palette runs out.
+func (s *Snapshot) StencilImage() *Image {
+	m := NewImage(s.Color.Width, s.Color.Height)
+	for i, level := range s.Stencil {
+		m.Pixels[i] = stencilPalette[int(level)%len(stencilPalette)]
+	}
+	return m
+}
+
+// DepthImage shows depth in grey, stretched over the range of depths drawn so
+// the nonlinear depth buffer is readable. The far plane stays white.
+func (s *Snapshot) DepthImage() *Image {
+	m := NewImage(s.Color.Width, s.Color.Height)
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, d := range s.Depth {
+		if d < 1 {
+			min = math.Min(min, d)
+			max = math.Max(max, d)
+		}
+	}
+	scale := 0.0
+	if max > min {
+		scale = 1 / (max - min)
+	}
+	for i, d := range s.Depth {
+		v := 1.0
+		if d < 1 {
+			v = (d - min) * scale * 0.9
+		}
+		m.Pixels[i] = glm.Vec4d{v, v, v, 1}
+	}
+	return m
+}
+
+// ScreenshotName names the nth screenshot, counting from 1.
+func ScreenshotName(n int) string {
+	return fmt.Sprintf("screenshot_%03d", n)
+}
+
+// FrameName names the frame drawn after a tick of a recorded sequence. Each
+// sequence has its own directory, and its frames sort in tick order.
+func FrameName(sequence, tick int) string {
+	return filepath.Join(fmt.Sprintf("sequence_%03d", sequence), fmt.Sprintf("frame_%05d", tick))
+}
+
+// Save writes name.png into dir, with name_stencil.png and name_depth.png
+// when buffers is set. Directories in name are made as needed.
+func (s *Snapshot) Save(dir, name string, buffers bool) error {
+	base := filepath.Join(dir, name)
+	err := os.MkdirAll(filepath.Dir(base), 0755)
+	if err != nil {
+		return err
+	}
+	images := map[string]*Image{".png": s.Color}
+	if buffers {
+		images["_stencil.png"] = s.StencilImage()
+		images["_depth.png"] = s.DepthImage()
+	}
+	for suffix, image := range images {
+		err = writePNG(base+suffix, image)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePNG(filename string, image *Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return image.WritePNG(file)
+}
@@ -0,0 +1,115 @@
+package render
+
+import (
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// columns fills the columns of s from column first to the right edge.
+func columns(s *Software, first int) {
+	x := -1 + 2*float64(first)/float64(s.Color.Width)
+	s.Scissor(portal.Rect{glm.Vec2d{x, -1}, glm.Vec2d{1, 1}})
+}
+
+func TestStencilImage(t *testing.T) {
+	s := newFill(4, 2)
+	screen := triangles(s, []glm.Vec2d{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}, []int16{0, 1, 2, 0, 2, 3})
+	// columns reach levels 0, 3, 7 and 9, the last past the end of the palette
+	levels := []int{0, 3, 7, 9}
+	s.Stencil().Enable().NoDraw().NoDepth().Increment()
+	for level := 0; level < 9; level++ {
+		first := 0
+		for first < len(levels) && levels[first] <= level {
+			first++
+		}
+		columns(s, first)
+		s.Stencil().Mask(level)
+		fillWith(s, screen, red, 0.5)
+	}
+	s.NoScissor()
+	m := s.Snapshot().StencilImage()
+	want := []glm.Vec4d{{0, 0, 0, 1}, {0.1, 0.3, 0.9, 1}, {1, 1, 1, 1}, {0.9, 0.1, 0.1, 1}}
+	for i, p := range m.Pixels {
+		if p != want[i%4] {
+			t.Errorf("pixel %d at stencil level %d shows %v, want %v", i, s.Stencils[i], p, want[i%4])
+		}
+	}
+}
+
+func TestDepthImage(t *testing.T) {
+	s := newFill(4, 2)
+	screen := triangles(s, []glm.Vec2d{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}, []int16{0, 1, 2, 0, 2, 3})
+	// columns at depths 0.2, 0.6, the far plane, then 0.4
+	s.Stencil().DepthAlways()
+	for i, depth := range []float64{0.2, 0.6, 1, 0.4} {
+		columns(s, i)
+		fillWith(s, screen, red, depth)
+	}
+	s.NoScissor()
+	m := s.Snapshot().DepthImage()
+	// the nearest depth drawn is black and the farthest 0.9 grey
+	want := []float64{0, 0.9, 1, 0.45}
+	for i, p := range m.Pixels {
+		v := want[i%4]
+		if !p.ApproxEqual(glm.Vec4d{v, v, v, 1}) {
+			t.Errorf("pixel %d at depth %v shows %v, want grey %v", i, s.Depth[i], p, v)
+		}
+	}
+	// with nothing drawn everything is the far plane
+	s.Clear(black)
+	for i, p := range s.Snapshot().DepthImage().Pixels {
+		if p != (glm.Vec4d{1, 1, 1, 1}) {
+			t.Fatalf("cleared pixel %d shows %v", i, p)
+		}
+	}
+}
+
+// Capture names are relied on to sort in order and to be found by scripts,
+// so they don't change.
+func TestCaptureNames(t *testing.T) {
+	if name := ScreenshotName(7); name != "screenshot_007" {
+		t.Errorf("screenshot 7 is named %q", name)
+	}
+	if name := FrameName(2, 31); name != filepath.Join("sequence_002", "frame_00031") {
+		t.Errorf("frame 31 of sequence 2 is named %q", name)
+	}
+	if FrameName(1, 9) >= FrameName(1, 10) {
+		t.Errorf("%s sorts after %s", FrameName(1, 9), FrameName(1, 10))
+	}
+}
+
+func TestSnapshotSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newFill(4, 2)
+	snapshot := s.Snapshot()
+	name := FrameName(1, 1)
+	err = snapshot.Save(dir, name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{".png", "_stencil.png", "_depth.png"} {
+		m, err := LoadImage(filepath.Join(dir, name+suffix))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if m.Width != 4 || m.Height != 2 {
+			t.Errorf("%s is %dx%d, want 4x2", suffix, m.Width, m.Height)
+		}
+	}
+	err = snapshot.Save(dir, ScreenshotName(1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ScreenshotName(1)+"_depth.png")); !os.IsNotExist(err) {
+		t.Errorf("saved the depth buffer without being asked: %v", err)
+	}
+}
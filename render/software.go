@@ -0,0 +1,545 @@
+package render
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// Image is a buffer of colors with rows stored bottom up, as GL reads them.
+type Image struct {
+	Width, Height int
+	Pixels        []glm.Vec4d
+}
+
+func NewImage(width, height int) *Image {
+	return &Image{width, height, make([]glm.Vec4d, width*height)}
+}
+
+// RGBA converts the image into a top down image.RGBA.
+func (m *Image) RGBA() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, m.Width, m.Height))
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			c := m.Pixels[y*m.Width+x]
+			img.SetRGBA(x, m.Height-1-y, color.RGBA{byte8(c[0]), byte8(c[1]), byte8(c[2]), byte8(c[3])})
+		}
+	}
+	return img
+}
+
+func (m *Image) WritePNG(w io.Writer) error {
+	return png.Encode(w, m.RGBA())
+}
+
+func byte8(v float64) uint8 {
+	return uint8(clamp(v, 0, 1)*255 + 0.5)
+}
+
+// Diff counts the pixels of a and b whose channels differ by more than
+// tolerance, out of 255. Images of different sizes differ everywhere.
+func Diff(a, b image.Image, tolerance int) int {
+	ra, rb := a.Bounds(), b.Bounds()
+	if ra.Dx() != rb.Dx() || ra.Dy() != rb.Dy() {
+		return ra.Dx() * ra.Dy()
+	}
+	n := 0
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			r0, g0, b0, a0 := a.At(ra.Min.X+x, ra.Min.Y+y).RGBA()
+			r1, g1, b1, a1 := b.At(rb.Min.X+x, rb.Min.Y+y).RGBA()
+			for _, d := range []int{
+				int(r0>>8) - int(r1>>8),
+				int(g0>>8) - int(g1>>8),
+				int(b0>>8) - int(b1>>8),
+				int(a0>>8) - int(a1>>8),
+			} {
+				if d > tolerance || -d > tolerance {
+					n++
+					break
+				}
+			}
+		}
+	}
+	return n
+}
+
+type depthFunc int
+
+const (
+	depthLess depthFunc = iota
+	depthLEqual
+	depthAlways
+)
+
+type stencilOp int
+
+const (
+	stencilKeep stencilOp = iota
+	stencilIncrement
+	stencilDecrement
+)
+
+type mesh struct {
+	attributes []Attributes
+	elements   map[gl.Enum][]int16
+	material   *Material
+	texture    *Image
+}
+
+// Software is a Renderer which rasterizes into memory, running Go versions of
+// the shaders. It keeps an 8 bit stencil and a float depth buffer.
+type Software struct {
+	Color    *Image
+	Depth    []float64
+	Stencils []uint8
+	Frame    *Image
+	Shaders  map[string]Shader
+
+	uniforms map[string]*Uniforms
+	program  string
+	meshes   map[*gtk.Geometry]mesh
+
+	colorMask   bool
+	depthTest   bool
+	depthMask   bool
+	depthFunc   depthFunc
+	stencilTest bool
+	stencilRef  uint8
+	stencilOp   stencilOp
+	cull        bool
+	scissor     bool
+	scissorBox  [4]int // x0, y0, x1, y1 with x1, y1 exclusive
+}
+
+// NewSoftware makes a renderer of the given size running the shaders by
+// program name.
+func NewSoftware(width, height int, shaders map[string]Shader) *Software {
+	s := &Software{
+		Shaders:   shaders,
+		uniforms:  map[string]*Uniforms{},
+		meshes:    map[*gtk.Geometry]mesh{},
+		colorMask: true,
+		depthMask: true,
+		depthFunc: depthLess,
+	}
+	s.Resize(width, height)
+	return s
+}
+
+func (s *Software) NewGeometry(name string, vertices, normals []float64, elements map[gl.Enum][]int16) *gtk.Geometry {
+	return s.NewSurface(name, vertices, normals, nil, elements, nil)
+}
+
+// NewSurface decodes the material's texture straight away. A texture which
+// cannot be read leaves the surface untextured.
+func (s *Software) NewSurface(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *Material) *gtk.Geometry {
+	geo := &gtk.Geometry{}
+	as := make([]Attributes, len(vertices)/3)
+	for i := range as {
+		as[i].Position = glm.Vec4d{vertices[3*i], vertices[3*i+1], vertices[3*i+2], 1}
+		if 3*i+2 < len(normals) {
+			as[i].Normal = glm.Vec4d{normals[3*i], normals[3*i+1], normals[3*i+2], 0}
+		}
+		if 2*i+1 < len(texcoords) {
+			as[i].Texcoord = glm.Vec2d{texcoords[2*i], texcoords[2*i+1]}
+		}
+	}
+	m := mesh{as, elements, material, nil}
+	if material != nil && material.Texture != "" && len(texcoords) > 0 {
+		m.texture, _ = LoadImage(material.Texture)
+	}
+	s.meshes[geo] = m
+	return geo
+}
+
+func (s *Software) Resize(width, height int) {
+	s.Color = NewImage(width, height)
+	s.Depth = make([]float64, width*height)
+	s.Stencils = make([]uint8, width*height)
+	s.Frame = NewImage(width, height)
+}
+
+func (s *Software) Clear(c gtk.Color) {
+	fill := glm.Vec4d{float64(c[0]), float64(c[1]), float64(c[2]), float64(c[3])}
+	for i := range s.Color.Pixels {
+		s.Color.Pixels[i] = fill
+		s.Depth[i] = 1
+		s.Stencils[i] = 0
+	}
+	s.depthTest = true
+}
+
+func (s *Software) UseProgram(program string) {
+	s.program = program
+	if _, ok := s.uniforms[program]; !ok {
+		s.uniforms[program] = newUniforms()
+	}
+}
+
+func (s *Software) current() *Uniforms {
+	s.UseProgram(s.program)
+	return s.uniforms[s.program]
+}
+
+func (s *Software) UniformMatrix(name string, m glm.Mat4d) {
+	s.current().Values[name] = m
+}
+
+func (s *Software) UniformFloat(name string, v float64) {
+	s.current().Values[name] = v
+}
+
+func (s *Software) UniformVec2(name string, v glm.Vec2d) {
+	s.current().Values[name] = v
+}
+
+func (s *Software) UniformColor(name string, c gtk.Color) {
+	s.current().Values[name] = c
+}
+
+func (s *Software) UniformFrame(name string) {
+	s.current().textures[name] = s.Frame
+}
+
+func (s *Software) Stencil() Stencil {
+	return swStencil{s}
+}
+
+func (s *Software) Cull(enabled bool) {
+	s.cull = enabled
+}
+
+func (s *Software) Scissor(rect portal.Rect) {
+	w, h := float64(s.Color.Width), float64(s.Color.Height)
+	s.scissor = true
+	s.scissorBox = [4]int{
+		int(math.Floor((rect.Min[0] + 1) / 2 * w)),
+		int(math.Floor((rect.Min[1] + 1) / 2 * h)),
+		int(math.Ceil((rect.Max[0] + 1) / 2 * w)),
+		int(math.Ceil((rect.Max[1] + 1) / 2 * h)),
+	}
+}
+
+func (s *Software) NoScissor() {
+	s.scissor = false
+}
+
+func (s *Software) CaptureFrame() {
+	copy(s.Frame.Pixels, s.Color.Pixels)
+}
+
+func (s *Software) StencilBits() int {
+	return 8
+}
+
+func (s *Software) Snapshot() *Snapshot {
+	c := NewImage(s.Color.Width, s.Color.Height)
+	copy(c.Pixels, s.Color.Pixels)
+	return &Snapshot{
+		c,
+		append([]float64{}, s.Depth...),
+		append([]uint8{}, s.Stencils...),
+	}
+}
+
+func (s *Software) DrawGeometry(geo *gtk.Geometry, lines bool) {
+	m, ok := s.meshes[geo]
+	shader, sok := s.Shaders[s.program]
+	if !ok || !sok {
+		return
+	}
+	u := s.current()
+	u.Values["material"] = 0.0
+	u.Values["textured"] = 0.0
+	if m.material != nil {
+		u.Values["material"] = 1.0
+		u.Values["diffuse"] = m.material.Diffuse
+		if m.texture != nil {
+			u.Values["textured"] = 1.0
+			u.textures["diffuseTexture"] = m.texture
+		}
+	}
+	vs := make([]Vertex, len(m.attributes))
+	for i, a := range m.attributes {
+		vs[i] = shader.Vertex(u, a)
+	}
+	// map order is random, sort the draw types so frames are repeatable
+	types := []int{}
+	for t := range m.elements {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+	for _, t := range types {
+		drawType := gl.Enum(t)
+		if lines != (drawType == gl.LINES) {
+			continue
+		}
+		es := m.elements[drawType]
+		switch drawType {
+		case gl.LINES:
+			for i := 0; i+1 < len(es); i += 2 {
+				s.line(shader, u, vs[es[i]], vs[es[i+1]])
+			}
+		case gl.TRIANGLES:
+			for i := 0; i+2 < len(es); i += 3 {
+				s.triangle(shader, u, vs[es[i]], vs[es[i+1]], vs[es[i+2]])
+			}
+		case gl.TRIANGLE_STRIP:
+			for i := 0; i+2 < len(es); i++ {
+				if i%2 == 0 {
+					s.triangle(shader, u, vs[es[i]], vs[es[i+1]], vs[es[i+2]])
+				} else {
+					s.triangle(shader, u, vs[es[i+1]], vs[es[i]], vs[es[i+2]])
+				}
+			}
+		case gl.TRIANGLE_FAN:
+			for i := 1; i+1 < len(es); i++ {
+				s.triangle(shader, u, vs[es[0]], vs[es[i]], vs[es[i+1]])
+			}
+		}
+	}
+}
+
+// clipPlanes lists the signed distances a clipped vertex must keep positive:
+// the near plane followed by the shader's clip distances.
+func clipPlanes(v Vertex) []float64 {
+	ds := make([]float64, 0, 1+len(v.Clip))
+	ds = append(ds, v.Position[2]+v.Position[3])
+	return append(ds, v.Clip...)
+}
+
+func lerpVertex(a, b Vertex, t float64) Vertex {
+	v := Vertex{
+		a.Position.Mul(1 - t).Add(b.Position.Mul(t)),
+		make([]float64, len(a.Clip)),
+		make([]float64, len(a.Varying)),
+	}
+	for i := range v.Clip {
+		v.Clip[i] = a.Clip[i]*(1-t) + b.Clip[i]*t
+	}
+	for i := range v.Varying {
+		v.Varying[i] = a.Varying[i]*(1-t) + b.Varying[i]*t
+	}
+	return v
+}
+
+// clipPolygon cuts a polygon against each clip plane in turn.
+func clipPolygon(poly []Vertex) []Vertex {
+	if len(poly) == 0 {
+		return poly
+	}
+	planes := len(clipPlanes(poly[0]))
+	for p := 0; p < planes && len(poly) > 0; p++ {
+		out := []Vertex{}
+		for i, a := range poly {
+			b := poly[(i+1)%len(poly)]
+			da, db := clipPlanes(a)[p], clipPlanes(b)[p]
+			if da >= 0 {
+				out = append(out, a)
+			}
+			if (da >= 0) != (db >= 0) {
+				out = append(out, lerpVertex(a, b, da/(da-db)))
+			}
+		}
+		poly = out
+	}
+	return poly
+}
+
+// fragment is a vertex after the perspective divide and viewport transform.
+type fragment struct {
+	x, y, z float64
+	invW    float64
+	varying []float64 // premultiplied by invW
+}
+
+func (s *Software) window(v Vertex) fragment {
+	w := v.Position[3]
+	f := fragment{
+		(v.Position[0]/w + 1) / 2 * float64(s.Color.Width),
+		(v.Position[1]/w + 1) / 2 * float64(s.Color.Height),
+		(v.Position[2]/w + 1) / 2,
+		1 / w,
+		make([]float64, len(v.Varying)),
+	}
+	for i, x := range v.Varying {
+		f.varying[i] = x / w
+	}
+	return f
+}
+
+func (s *Software) bounds() (int, int, int, int) {
+	if s.scissor {
+		b := s.scissorBox
+		return maxInt(b[0], 0), maxInt(b[1], 0), minInt(b[2], s.Color.Width), minInt(b[3], s.Color.Height)
+	}
+	return 0, 0, s.Color.Width, s.Color.Height
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func edge(a, b fragment, x, y float64) float64 {
+	return (b.x-a.x)*(y-a.y) - (b.y-a.y)*(x-a.x)
+}
+
+// topLeft reports whether pixel centers on edge a->b of a counter clockwise
+// triangle belong to it, so triangles sharing an edge never both cover a pixel.
+func topLeft(a, b fragment) bool {
+	return (a.y == b.y && b.x < a.x) || b.y > a.y
+}
+
+func (s *Software) triangle(shader Shader, u *Uniforms, a, b, c Vertex) {
+	poly := clipPolygon([]Vertex{a, b, c})
+	if len(poly) < 3 {
+		return
+	}
+	fs := make([]fragment, len(poly))
+	for i, v := range poly {
+		fs[i] = s.window(v)
+	}
+	for i := 1; i+1 < len(fs); i++ {
+		s.fill(shader, u, fs[0], fs[i], fs[i+1])
+	}
+}
+
+func (s *Software) fill(shader Shader, u *Uniforms, a, b, c fragment) {
+	area := edge(a, b, c.x, c.y)
+	if area == 0 || (s.cull && area < 0) {
+		return
+	}
+	if area < 0 {
+		b, c = c, b
+		area = -area
+	}
+	x0, y0, x1, y1 := s.bounds()
+	x0 = maxInt(x0, int(math.Floor(math.Min(a.x, math.Min(b.x, c.x)))))
+	y0 = maxInt(y0, int(math.Floor(math.Min(a.y, math.Min(b.y, c.y)))))
+	x1 = minInt(x1, int(math.Ceil(math.Max(a.x, math.Max(b.x, c.x))))+1)
+	y1 = minInt(y1, int(math.Ceil(math.Max(a.y, math.Max(b.y, c.y))))+1)
+	tlA, tlB, tlC := topLeft(b, c), topLeft(c, a), topLeft(a, b)
+	varying := make([]float64, len(a.varying))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			wa, wb, wc := edge(b, c, px, py), edge(c, a, px, py), edge(a, b, px, py)
+			if wa < 0 || wb < 0 || wc < 0 ||
+				(wa == 0 && !tlA) || (wb == 0 && !tlB) || (wc == 0 && !tlC) {
+				continue
+			}
+			wa, wb, wc = wa/area, wb/area, wc/area
+			invW := wa*a.invW + wb*b.invW + wc*c.invW
+			for i := range varying {
+				varying[i] = (wa*a.varying[i] + wb*b.varying[i] + wc*c.varying[i]) / invW
+			}
+			s.shade(shader, u, x, y, wa*a.z+wb*b.z+wc*c.z, varying)
+		}
+	}
+}
+
+func (s *Software) line(shader Shader, u *Uniforms, a, b Vertex) {
+	seg := clipPolygon([]Vertex{a, b})
+	if len(seg) < 2 {
+		return
+	}
+	fa, fb := s.window(seg[0]), s.window(seg[1])
+	x0, y0, x1, y1 := s.bounds()
+	steps := int(math.Ceil(math.Max(math.Abs(fb.x-fa.x), math.Abs(fb.y-fa.y))))
+	varying := make([]float64, len(fa.varying))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		x := int(math.Floor(fa.x + (fb.x-fa.x)*t))
+		y := int(math.Floor(fa.y + (fb.y-fa.y)*t))
+		if x < x0 || x >= x1 || y < y0 || y >= y1 {
+			continue
+		}
+		invW := fa.invW*(1-t) + fb.invW*t
+		for j := range varying {
+			varying[j] = (fa.varying[j]*(1-t) + fb.varying[j]*t) / invW
+		}
+		s.shade(shader, u, x, y, fa.z*(1-t)+fb.z*t, varying)
+	}
+}
+
+// shade runs the fragment shader and the stencil and depth tests for a pixel.
+func (s *Software) shade(shader Shader, u *Uniforms, x, y int, z float64, varying []float64) {
+	i := y*s.Color.Width + x
+	if s.stencilTest && s.Stencils[i] != s.stencilRef {
+		return
+	}
+	color, depth := shader.Fragment(u, varying, z)
+	if s.depthTest {
+		switch s.depthFunc {
+		case depthLess:
+			if !(depth < s.Depth[i]) {
+				return
+			}
+		case depthLEqual:
+			if !(depth <= s.Depth[i]) {
+				return
+			}
+		}
+		if s.depthMask {
+			s.Depth[i] = depth
+		}
+	}
+	if s.stencilTest {
+		switch s.stencilOp {
+		case stencilIncrement:
+			if s.Stencils[i] < math.MaxUint8 {
+				s.Stencils[i]++
+			}
+		case stencilDecrement:
+			if s.Stencils[i] > 0 {
+				s.Stencils[i]--
+			}
+		}
+	}
+	if s.colorMask {
+		s.Color.Pixels[i] = color
+	}
+}
+
+// swStencil follows the GL state changes made by gtk.Stencil.
+type swStencil struct {
+	s *Software
+}
+
+func (t swStencil) Enable() Stencil  { t.s.stencilTest = true; return t }
+func (t swStencil) Disable() Stencil { t.s.stencilTest = false; return t }
+func (t swStencil) Mask(level int) Stencil {
+	t.s.stencilRef = uint8(level)
+	return t
+}
+func (t swStencil) Draw() Stencil        { t.s.colorMask = true; return t }
+func (t swStencil) NoDraw() Stencil      { t.s.colorMask = false; return t }
+func (t swStencil) Depth() Stencil       { t.s.depthTest = true; t.s.depthMask = true; return t }
+func (t swStencil) NoDepth() Stencil     { t.s.depthTest = false; return t }
+func (t swStencil) NoDepthMask() Stencil { t.s.depthMask = false; return t }
+func (t swStencil) DepthLE() Stencil     { t.s.depthFunc = depthLEqual; return t }
+func (t swStencil) DepthAlways() Stencil { t.s.depthFunc = depthAlways; return t }
+func (t swStencil) Increment() Stencil   { t.s.stencilOp = stencilIncrement; return t }
+func (t swStencil) Decrement() Stencil   { t.s.stencilOp = stencilDecrement; return t }
+func (t swStencil) Keep() Stencil        { t.s.stencilOp = stencilKeep; return t }
@@ -0,0 +1,231 @@
+package render
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+var _ Renderer = NewSoftware(1, 1, nil)
+
+var (
+	black = gtk.Color{0, 0, 0, 1}
+	red   = gtk.Color{1, 0, 0, 1}
+	blue  = gtk.Color{0, 0, 1, 1}
+)
+
+func newFill(width, height int) *Software {
+	s := NewSoftware(width, height, map[string]Shader{"fill": FillShader{}})
+	s.Clear(black)
+	s.UseProgram("fill")
+	return s
+}
+
+// triangles makes geometry from clip space points, drawn with w=1.
+func triangles(s *Software, points []glm.Vec2d, elements []int16) *gtk.Geometry {
+	vs := []float64{}
+	for _, p := range points {
+		vs = append(vs, p[0], p[1], 0)
+	}
+	return s.NewGeometry("triangles", vs, nil, map[gl.Enum][]int16{gl.TRIANGLES: elements})
+}
+
+func fillWith(s *Software, geo *gtk.Geometry, c gtk.Color, depth float64) {
+	s.UniformColor("color", c)
+	s.UniformFloat("depth", depth)
+	s.DrawGeometry(geo, false)
+}
+
+func vec(c gtk.Color) glm.Vec4d {
+	return glm.Vec4d{float64(c[0]), float64(c[1]), float64(c[2]), float64(c[3])}
+}
+
+func count(s *Software, c gtk.Color) int {
+	n := 0
+	for _, p := range s.Color.Pixels {
+		if p == vec(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// coverage draws each triangle alone and counts how many cover each pixel.
+func coverage(t *testing.T, points []glm.Vec2d, elements []int16) []int {
+	counts := make([]int, 8*8)
+	for i := 0; i+2 < len(elements); i += 3 {
+		s := newFill(8, 8)
+		s.Stencil().NoDepth()
+		fillWith(s, triangles(s, points, elements[i:i+3]), red, 0.5)
+		for j, p := range s.Color.Pixels {
+			if p == vec(red) {
+				counts[j]++
+			}
+		}
+	}
+	return counts
+}
+
+func TestFillRuleSharedEdges(t *testing.T) {
+	// the diagonal and the fan's spokes run through pixel centers
+	meshes := []struct {
+		name     string
+		points   []glm.Vec2d
+		elements []int16
+		covered  int
+	}{
+		{"square", []glm.Vec2d{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}}, []int16{0, 1, 2, 0, 2, 3}, 16},
+		{"reversed square", []glm.Vec2d{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}}, []int16{0, 2, 1, 0, 3, 2}, 16},
+		{"fan", []glm.Vec2d{{0.125, 0.125}, {-1, -1}, {1.25, -1}, {1.25, 1.25}, {-1, 1.25}}, []int16{0, 1, 2, 0, 2, 3, 0, 3, 4, 0, 4, 1}, 64},
+	}
+	for _, m := range meshes {
+		covered := 0
+		for i, c := range coverage(t, m.points, m.elements) {
+			if c > 1 {
+				t.Errorf("%s: pixel %d drawn %d times", m.name, i, c)
+			}
+			covered += c
+		}
+		if covered != m.covered {
+			t.Errorf("%s: covered %d pixels, want %d", m.name, covered, m.covered)
+		}
+	}
+}
+
+func TestCull(t *testing.T) {
+	s := newFill(8, 8)
+	back := triangles(s, []glm.Vec2d{{-1, -1}, {1, 1}, {1, -1}}, []int16{0, 1, 2})
+	s.Cull(true)
+	fillWith(s, back, red, 0.5)
+	if n := count(s, red); n != 0 {
+		t.Errorf("culled triangle drew %d pixels", n)
+	}
+	s.Cull(false)
+	fillWith(s, back, red, 0.5)
+	if n := count(s, red); n == 0 {
+		t.Error("triangle drew nothing with culling off")
+	}
+}
+
+func TestStencil(t *testing.T) {
+	s := newFill(8, 8)
+	left := triangles(s, []glm.Vec2d{{-1, -1}, {0, -1}, {0, 1}, {-1, 1}}, []int16{0, 1, 2, 0, 2, 3})
+	screen := triangles(s, []glm.Vec2d{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}, []int16{0, 1, 2, 0, 2, 3})
+	// mark the left half as level 1 without drawing it
+	s.Stencil().Enable().Mask(0).Increment().NoDraw().NoDepth()
+	fillWith(s, left, red, 0.5)
+	if n := count(s, red); n != 0 {
+		t.Errorf("drew %d pixels with the color masked", n)
+	}
+	for i, level := range s.Stencils {
+		want := uint8(0)
+		if i%8 < 4 {
+			want = 1
+		}
+		if level != want {
+			t.Fatalf("pixel %d at stencil level %d, want %d", i, level, want)
+		}
+	}
+	// only level 1 takes the fill
+	s.Stencil().Mask(1).Keep().Draw()
+	fillWith(s, screen, blue, 0.5)
+	if n := count(s, blue); n != 32 {
+		t.Errorf("filled %d pixels of level 1, want 32", n)
+	}
+	for i := range s.Color.Pixels {
+		if i%8 >= 4 && s.Color.Pixels[i] != vec(black) {
+			t.Fatalf("pixel %d outside the stencil was drawn", i)
+		}
+	}
+	// decrementing stops at zero
+	s.Stencil().Disable()
+	s.Stencil().Enable().Mask(1).Decrement()
+	fillWith(s, screen, blue, 0.5)
+	for i, level := range s.Stencils {
+		if level != 0 {
+			t.Fatalf("pixel %d left at stencil level %d", i, level)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	s := newFill(8, 8)
+	screen := triangles(s, []glm.Vec2d{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}, []int16{0, 1, 2, 0, 2, 3})
+	fillWith(s, screen, red, 0.3)
+	fillWith(s, screen, blue, 0.6)
+	if n := count(s, red); n != 64 {
+		t.Errorf("a farther fill covered the nearer one, %d red pixels left", n)
+	}
+	// equal depth fails the default less test but passes less or equal
+	fillWith(s, screen, blue, 0.3)
+	if n := count(s, blue); n != 0 {
+		t.Errorf("an equally deep fill passed the less test on %d pixels", n)
+	}
+	s.Stencil().DepthLE()
+	fillWith(s, screen, blue, 0.3)
+	if n := count(s, blue); n != 64 {
+		t.Errorf("an equally deep fill passed less or equal on %d pixels, want 64", n)
+	}
+	s.Stencil().DepthAlways().NoDepthMask()
+	fillWith(s, screen, red, 0.9)
+	if n := count(s, red); n != 64 {
+		t.Errorf("a fill ignoring depth drew %d pixels, want 64", n)
+	}
+	for i, d := range s.Depth {
+		if math.Abs(d-0.3) > 1e-12 {
+			t.Fatalf("pixel %d depth %v changed with the depth mask off", i, d)
+		}
+	}
+	s.Clear(black)
+	for i, d := range s.Depth {
+		if d != 1 || s.Stencils[i] != 0 {
+			t.Fatalf("pixel %d not cleared", i)
+		}
+	}
+}
+
+func TestNearPlaneClip(t *testing.T) {
+	s := NewSoftware(8, 8, map[string]Shader{"scene": SceneShader{}})
+	s.Clear(black)
+	s.UseProgram("scene")
+	s.UniformMatrix("projection", glm.Perspectived(90, 1, 1, 10))
+	// a floor running from behind the camera into the distance
+	vs := []float64{-1, -1, 5, 1, -1, 5, 1, -1, -5, -1, -1, -5}
+	floor := s.NewGeometry("floor", vs, nil, map[gl.Enum][]int16{gl.TRIANGLES: {0, 1, 2, 0, 2, 3}})
+	s.DrawGeometry(floor, false)
+	drawn := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if s.Depth[y*8+x] < 1 {
+				drawn++
+				if y >= 4 {
+					t.Errorf("floor drawn above the horizon at (%d, %d)", x, y)
+				}
+			}
+		}
+	}
+	if drawn == 0 {
+		t.Error("floor crossing the near plane was not drawn")
+	}
+	for _, d := range s.Depth {
+		if d < 0 {
+			t.Fatalf("depth %v in front of the near plane", d)
+		}
+	}
+}
+
+func TestSceneNormalScaled(t *testing.T) {
+	// a slope rising along x, stretched four times as tall, is steeper and
+	// its normal leans further toward x
+	u := newUniforms()
+	u.Values["worldview"] = glm.Translate3Dd(1, 2, 3).Mul4(glm.Scale3Dd(1, 4, 1))
+	normal := glm.Vec4d{-1, 1, 0, 0}.Normalize()
+	v := SceneShader{}.Vertex(u, Attributes{Position: glm.Vec4d{0, 0, 0, 1}, Normal: normal})
+	got := glm.Vec3d{v.Varying[3], v.Varying[4], v.Varying[5]}.Normalize()
+	want := glm.Vec3d{-4, 1, 0}.Normalize()
+	if !got.ApproxEqual(want) {
+		t.Errorf("scaled normal %v, want %v", got, want)
+	}
+}
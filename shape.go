@@ -0,0 +1,154 @@
+package portal
+
+import (
+	"fmt"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Shape is the outline of a portal horizon in local horizon space, where the
+// horizon's extents span [-1,1] along PlaneV and up.
+type Shape interface {
+	// Outline lists the mesh vertices counter-clockwise about the normal.
+	Outline() []glm.Vec2d
+	// Elements indexes Outline for the stencil triangles and the debug lines.
+	// Index len(Outline) is the center and len(Outline)+1 the tip of the normal.
+	Elements() map[gl.Enum][]int16
+	// Contains decides whether a traveller crossing the plane at (x, y) passes
+	// through the portal.
+	Contains(x, y float64) bool
+}
+
+type Rectangle struct{}
+
+func (Rectangle) Outline() []glm.Vec2d {
+	return []glm.Vec2d{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}}
+}
+
+func (Rectangle) Elements() map[gl.Enum][]int16 {
+	return convexElements(4)
+}
+
+func (Rectangle) Contains(x, y float64) bool {
+	return math.Abs(x) <= 1 && math.Abs(y) <= 1
+}
+
+type Ellipse struct {
+	Segments int
+}
+
+const DefaultEllipseSegments = 32
+
+func (e Ellipse) segments() int {
+	if e.Segments < 3 {
+		return DefaultEllipseSegments
+	}
+	return e.Segments
+}
+
+func (e Ellipse) Outline() []glm.Vec2d {
+	n := e.segments()
+	points := make([]glm.Vec2d, n)
+	for i := range points {
+		theta := 2 * math.Pi * float64(i) / float64(n)
+		points[i] = glm.Vec2d{math.Cos(theta), math.Sin(theta)}
+	}
+	return points
+}
+
+func (e Ellipse) Elements() map[gl.Enum][]int16 {
+	return convexElements(e.segments())
+}
+
+func (Ellipse) Contains(x, y float64) bool {
+	return x*x+y*y <= 1
+}
+
+type Polygon struct {
+	Points []glm.Vec2d
+}
+
+// NewPolygon checks that points form a convex polygon, turning one way and
+// winding around once, and orders them counter-clockwise.
+func NewPolygon(points []glm.Vec2d) (Polygon, error) {
+	n := len(points)
+	if n < 3 {
+		return Polygon{}, fmt.Errorf("polygon needs at least 3 points, got %d", n)
+	}
+	sign := 0.0
+	winding := 0.0
+	for i := range points {
+		a := points[(i+1)%n].Sub(points[i])
+		b := points[(i+2)%n].Sub(points[(i+1)%n])
+		turn := cross2D(a, b)
+		winding += math.Atan2(turn, a.Dot(b))
+		if turn == 0 {
+			continue
+		}
+		if sign == 0 {
+			sign = turn
+		} else if sign*turn < 0 {
+			return Polygon{}, fmt.Errorf("polygon is not convex at point %d", (i+1)%n)
+		}
+	}
+	if sign == 0 {
+		return Polygon{}, fmt.Errorf("polygon has no area")
+	}
+	// turning the same way at every point, a star winds around more than once
+	if math.Abs(winding) > 2*math.Pi+1e-6 {
+		return Polygon{}, fmt.Errorf("polygon crosses itself, winding %.0f times", math.Abs(winding)/(2*math.Pi))
+	}
+	ordered := make([]glm.Vec2d, n)
+	for i, p := range points {
+		if sign < 0 {
+			ordered[n-1-i] = p
+		} else {
+			ordered[i] = p
+		}
+	}
+	return Polygon{ordered}, nil
+}
+
+func (p Polygon) Outline() []glm.Vec2d {
+	return p.Points
+}
+
+func (p Polygon) Elements() map[gl.Enum][]int16 {
+	return convexElements(len(p.Points))
+}
+
+func (p Polygon) Contains(x, y float64) bool {
+	n := len(p.Points)
+	q := glm.Vec2d{x, y}
+	for i, a := range p.Points {
+		b := p.Points[(i+1)%n]
+		if cross2D(b.Sub(a), q.Sub(a)) < 0 {
+			return false
+		}
+	}
+	return n > 0
+}
+
+func cross2D(a, b glm.Vec2d) float64 {
+	return a[0]*b[1] - a[1]*b[0]
+}
+
+// convexElements fans a convex outline of n vertices into triangles facing
+// away from the normal, the side the horizon is entered from, and outlines it
+// for debugging.
+func convexElements(n int) map[gl.Enum][]int16 {
+	triangles := make([]int16, 0, 3*(n-2))
+	for i := 1; i+1 < n; i++ {
+		triangles = append(triangles, 0, int16(i+1), int16(i))
+	}
+	lines := make([]int16, 0, 2*n+2)
+	for i := 0; i < n; i++ {
+		lines = append(lines, int16(i), int16((i+1)%n))
+	}
+	lines = append(lines, int16(n), int16(n+1))
+	return map[gl.Enum][]int16{
+		gl.TRIANGLES: triangles,
+		gl.LINES:     lines,
+	}
+}
@@ -0,0 +1,134 @@
+package portal
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+func area(points []glm.Vec2d) float64 {
+	a := 0.0
+	for i, p := range points {
+		a += cross2D(p, points[(i+1)%len(points)])
+	}
+	return a / 2
+}
+
+func TestShapesCounterClockwise(t *testing.T) {
+	square, err := NewPolygon([]glm.Vec2d{{1, 1}, {1, -1}, {-1, -1}, {-1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes := []Shape{Rectangle{}, Ellipse{}, Ellipse{Segments: 5}, square}
+	for _, s := range shapes {
+		outline := s.Outline()
+		if area(outline) <= 0 {
+			t.Errorf("%T outline %v is not counter-clockwise", s, outline)
+		}
+		n := len(outline)
+		for i, a := range outline {
+			b, c := outline[(i+1)%n], outline[(i+2)%n]
+			if cross2D(b.Sub(a), c.Sub(b)) <= 0 {
+				t.Errorf("%T outline turns clockwise at %v", s, b)
+			}
+		}
+	}
+}
+
+func TestShapeElementsCoverOutline(t *testing.T) {
+	shapes := []Shape{Rectangle{}, Ellipse{Segments: 6}}
+	for _, s := range shapes {
+		outline := s.Outline()
+		triangles := s.Elements()[gl.TRIANGLES]
+		if len(triangles) != 3*(len(outline)-2) {
+			t.Fatalf("%T has %d triangle indices for %d vertices", s, len(triangles), len(outline))
+		}
+		covered := 0.0
+		for i := 0; i < len(triangles); i += 3 {
+			tri := []glm.Vec2d{outline[triangles[i]], outline[triangles[i+1]], outline[triangles[i+2]]}
+			// the stencil triangles face away from the normal
+			a := area(tri)
+			if a >= 0 {
+				t.Errorf("%T triangle %v faces the normal", s, tri)
+			}
+			covered -= a
+		}
+		if !approx(covered, area(outline)) {
+			t.Errorf("%T triangles cover %v of %v", s, covered, area(outline))
+		}
+	}
+}
+
+func TestRectangleContains(t *testing.T) {
+	r := Rectangle{}
+	for _, p := range r.Outline() {
+		if !r.Contains(p[0], p[1]) {
+			t.Errorf("corner %v is outside", p)
+		}
+	}
+	if r.Contains(1.01, 0) || r.Contains(0, -1.01) {
+		t.Error("points past the edges are inside")
+	}
+}
+
+func TestEllipseContains(t *testing.T) {
+	e := Ellipse{}
+	d := math.Sqrt(0.5)
+	// near the diagonal, where the unit circle falls short of the square
+	if !e.Contains(d-0.01, d-0.01) {
+		t.Error("a point just inside the circle on the diagonal is outside")
+	}
+	if e.Contains(d+0.01, d+0.01) || e.Contains(-0.9, 0.9) {
+		t.Error("a point in the square's corner is inside the circle")
+	}
+	if !e.Contains(0, 1) || !e.Contains(-1, 0) || e.Contains(1.01, 0) {
+		t.Error("the circle's extents are wrong")
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	triangle, err := NewPolygon([]glm.Vec2d{{-1, -1}, {1, -1}, {0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := []glm.Vec2d{{0, 0}, {0, 0.9}, {-0.9, -0.95}, {0, -1}}
+	outside := []glm.Vec2d{{-0.6, 0.5}, {0.6, 0.5}, {0, -1.01}, {0, 1.01}}
+	// wound the other way, the points are reordered and test the same
+	reversed, err := NewPolygon([]glm.Vec2d{{0, 1}, {1, -1}, {-1, -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Polygon{triangle, reversed} {
+		for _, q := range inside {
+			if !p.Contains(q[0], q[1]) {
+				t.Errorf("%v is outside %v", q, p.Points)
+			}
+		}
+		for _, q := range outside {
+			if p.Contains(q[0], q[1]) {
+				t.Errorf("%v is inside %v", q, p.Points)
+			}
+		}
+	}
+}
+
+func TestNewPolygonRejects(t *testing.T) {
+	star := make([]glm.Vec2d, 5)
+	for i := range star {
+		theta := 2 * math.Pi * float64(2*i) / 5
+		star[i] = glm.Vec2d{math.Cos(theta), math.Sin(theta)}
+	}
+	outlines := map[string][]glm.Vec2d{
+		"star":      star,
+		"concave":   {{-1, -1}, {1, -1}, {0, 0}, {1, 1}, {-1, 1}},
+		"bow tie":   {{-1, -1}, {1, 1}, {1, -1}, {-1, 1}},
+		"collinear": {{0, 0}, {1, 0}, {2, 0}},
+		"too few":   {{0, 0}, {1, 0}},
+	}
+	for name, outline := range outlines {
+		if _, err := NewPolygon(outline); err == nil {
+			t.Errorf("%s outline %v is accepted", name, outline)
+		}
+	}
+}
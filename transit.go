@@ -0,0 +1,85 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Transit is how a portal carries motion between horizons of different size.
+type Transit int
+
+const (
+	// PreserveSpeed rotates velocity through the portal and keeps its length,
+	// and bodies keep their size.
+	PreserveSpeed Transit = iota
+	// ScaleWithPortal grows or shrinks bodies and their velocity by the size
+	// of the exit relative to the entry.
+	ScaleWithPortal
+)
+
+func (t Transit) String() string {
+	switch t {
+	case PreserveSpeed:
+		return "preserve"
+	case ScaleWithPortal:
+		return "scale"
+	}
+	return "unknown"
+}
+
+// ParseTransit reads a transit mode by its String name.
+func ParseTransit(name string) (Transit, bool) {
+	switch name {
+	case "preserve", "":
+		return PreserveSpeed, true
+	case "scale":
+		return ScaleWithPortal, true
+	}
+	return PreserveSpeed, false
+}
+
+// Passage carries bodies from a portal's entry side to its exit side.
+type Passage struct {
+	Matrix   glm.Mat4d // entry side world space into exit side world space
+	Rotation glm.Quatd // the rigid part of Matrix
+	Scale    float64   // exit size over entry size, across the horizon
+	Transit  Transit
+}
+
+// Passage decomposes the inverse of the portal's transform. The entry frame
+// axes map onto the exit frame axes scaled by the ratio of the extents, which
+// gives the rotation and the scale without a general decomposition.
+func (p *Portal) Passage() Passage {
+	m := p.Transform.Inv()
+	entry := NewPortalFrame(p.EventHorizon)
+	x := m.Mul4x1(entry.X)
+	y := m.Mul4x1(entry.Y)
+	exit := PortalFrame{X: x.Normalize(), Y: y.Normalize(), Z: Cross3Dv(x, y).Normalize()}
+	return Passage{
+		m,
+		entry.Rotation(exit),
+		math.Sqrt(x.Len() * y.Len()),
+		p.Transit,
+	}
+}
+
+// Point maps a position through the portal, so it keeps its place relative
+// to the horizon.
+func (g *Passage) Point(p glm.Vec4d) glm.Vec4d {
+	return g.Matrix.Mul4x1(p)
+}
+
+// Vector maps a velocity or displacement through the portal.
+func (g *Passage) Vector(v glm.Vec4d) glm.Vec4d {
+	r := g.Rotation.Rotate(glm.Vec3d{v[0], v[1], v[2]})
+	s := g.Size()
+	return glm.Vec4d{r[0] * s, r[1] * s, r[2] * s, 0}
+}
+
+// Size is the factor bodies grow by passing through.
+func (g *Passage) Size() float64 {
+	if g.Transit == ScaleWithPortal {
+		return g.Scale
+	}
+	return 1
+}
@@ -0,0 +1,96 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"testing"
+)
+
+var (
+	// a 1 by 1 horizon at the origin facing -z
+	smallHorizon = Quad{
+		glm.Vec4d{0, 0, 0, 1},
+		glm.Vec4d{0, 0, -1, 0},
+		glm.Vec4d{-1, 0, 0, 0},
+		glm.Vec4d{1, 1, 1, 0},
+		Rectangle{},
+	}
+	// a 2 by 2 horizon at (10, 0, 0) facing +x
+	largeHorizon = Quad{
+		glm.Vec4d{10, 0, 0, 1},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{0, 0, -1, 0},
+		glm.Vec4d{2, 2, 2, 0},
+		Rectangle{},
+	}
+)
+
+func passage(entry, exit Quad, transit Transit) Passage {
+	p := NewPortal(entry, exit)
+	p.Transit = transit
+	return p.Passage()
+}
+
+func TestPassageScale(t *testing.T) {
+	for _, transit := range []Transit{PreserveSpeed, ScaleWithPortal} {
+		g := passage(smallHorizon, largeHorizon, transit)
+		if !approx(g.Scale, 2) {
+			t.Errorf("%v: scale %v, want 2", transit, g.Scale)
+		}
+		// entering along -z leaves the exit heading away from it, along -x
+		v := g.Vector(glm.Vec4d{0, 0, -3, 0})
+		want := glm.Vec4d{-3, 0, 0, 0}
+		size := 1.0
+		if transit == ScaleWithPortal {
+			want = glm.Vec4d{-6, 0, 0, 0}
+			size = 2
+		}
+		if !approxVec(v, want) {
+			t.Errorf("%v: velocity %v, want %v", transit, v, want)
+		}
+		if !approx(g.Size(), size) {
+			t.Errorf("%v: size %v, want %v", transit, g.Size(), size)
+		}
+		// whatever the transit, positions keep their place relative to the
+		// horizon, so a point 0.5 across the entry is 1 across the exit
+		p := g.Point(glm.Vec4d{0.5, 0.25, 0, 1})
+		if !approxVec(p, glm.Vec4d{10, 0.5, -1, 1}) {
+			t.Errorf("%v: point mapped to %v", transit, p)
+		}
+		r := g.Rotation.Rotate(glm.Vec3d{0, 0, -1})
+		if !r.ApproxEqual(glm.Vec3d{-1, 0, 0}) || !approx(g.Rotation.Len(), 1) {
+			t.Errorf("%v: rotation takes -z to %v", transit, r)
+		}
+	}
+}
+
+func TestPassageRoundTrip(t *testing.T) {
+	for _, transit := range []Transit{PreserveSpeed, ScaleWithPortal} {
+		there := passage(smallHorizon, largeHorizon, transit)
+		back := passage(largeHorizon, smallHorizon, transit)
+		if !approx(there.Size()*back.Size(), 1) {
+			t.Errorf("%v: sizes %v and %v do not undo each other", transit, there.Size(), back.Size())
+		}
+		p := glm.Vec4d{0.3, -0.2, 0.1, 1}
+		if q := back.Point(there.Point(p)); !approxVec(q, p) {
+			t.Errorf("%v: point %v came back at %v", transit, p, q)
+		}
+		v := glm.Vec4d{1, 2, -3, 0}
+		if w := back.Vector(there.Vector(v)); !approxVec(w, v) {
+			t.Errorf("%v: velocity %v came back as %v", transit, v, w)
+		}
+	}
+}
+
+func TestParseTransit(t *testing.T) {
+	for _, transit := range []Transit{PreserveSpeed, ScaleWithPortal} {
+		if parsed, ok := ParseTransit(transit.String()); !ok || parsed != transit {
+			t.Errorf("%v does not parse back", transit)
+		}
+	}
+	if parsed, ok := ParseTransit(""); !ok || parsed != PreserveSpeed {
+		t.Error("no transit does not preserve speed")
+	}
+	if _, ok := ParseTransit("warp"); ok {
+		t.Error("parsed an unknown transit")
+	}
+}
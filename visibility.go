@@ -0,0 +1,87 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// Rect is an axis aligned rectangle in normalized device coordinates.
+type Rect struct {
+	Min glm.Vec2d
+	Max glm.Vec2d
+}
+
+var FullScreen = Rect{glm.Vec2d{-1, -1}, glm.Vec2d{1, 1}}
+
+func (r Rect) Empty() bool {
+	return r.Min[0] >= r.Max[0] || r.Min[1] >= r.Max[1]
+}
+
+func (r Rect) Intersect(o Rect) Rect {
+	return Rect{
+		glm.Vec2d{math.Max(r.Min[0], o.Min[0]), math.Max(r.Min[1], o.Min[1])},
+		glm.Vec2d{math.Min(r.Max[0], o.Max[0]), math.Min(r.Max[1], o.Max[1])},
+	}
+}
+
+// FacesViewer reports whether eye is on the front side of the horizon, the
+// side it is entered from.
+func (q *Quad) FacesViewer(eye glm.Vec4d) bool {
+	return eye.Sub(q.Center).Dot(q.Normal) < 0
+}
+
+// ScreenRect bounds the horizon on screen once projected by mvp, clipping it
+// against the near plane. It is false if nothing of the horizon is in front
+// of the near plane.
+func (q *Quad) ScreenRect(mvp glm.Mat4d) (Rect, bool) {
+	points := []glm.Vec4d{}
+	for _, p := range q.worldOutline() {
+		points = append(points, mvp.Mul4x1(p))
+	}
+	points = clipNear(points)
+	if len(points) == 0 {
+		return Rect{}, false
+	}
+	inf := math.Inf(1)
+	r := Rect{glm.Vec2d{inf, inf}, glm.Vec2d{-inf, -inf}}
+	for _, p := range points {
+		x, y := p[0]/p[3], p[1]/p[3]
+		r.Min = glm.Vec2d{math.Min(r.Min[0], x), math.Min(r.Min[1], y)}
+		r.Max = glm.Vec2d{math.Max(r.Max[0], x), math.Max(r.Max[1], y)}
+	}
+	return r, true
+}
+
+// clipNear clips a convex polygon in clip space to z >= -w.
+func clipNear(points []glm.Vec4d) []glm.Vec4d {
+	clipped := []glm.Vec4d{}
+	dist := func(p glm.Vec4d) float64 { return p[2] + p[3] }
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		da, db := dist(a), dist(b)
+		if da >= 0 {
+			clipped = append(clipped, a)
+		}
+		if (da >= 0) != (db >= 0) {
+			clipped = append(clipped, a.Add(b.Sub(a).Mul(da/(da-db))))
+		}
+	}
+	return clipped
+}
+
+// Visible decides whether a portal needs rendering from a view. The view is
+// the cameraview composed with the model transform of the current portal
+// level, and parent is the screen rectangle that level is confined to. The
+// portal's own, narrower, screen rectangle is returned.
+func (p *Portal) Visible(projection, view glm.Mat4d, parent Rect) (Rect, bool) {
+	eye := view.Inv().Mul4x1(glm.Vec4d{0, 0, 0, 1})
+	if !p.EventHorizon.FacesViewer(eye) {
+		return Rect{}, false
+	}
+	r, ok := p.EventHorizon.ScreenRect(projection.Mul4(view))
+	if !ok {
+		return Rect{}, false
+	}
+	r = r.Intersect(parent)
+	return r, !r.Empty()
+}
@@ -0,0 +1,72 @@
+package portal
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"testing"
+)
+
+func approxRect(a, b Rect) bool {
+	return approx(a.Min[0], b.Min[0]) && approx(a.Min[1], b.Min[1]) &&
+		approx(a.Max[0], b.Max[0]) && approx(a.Max[1], b.Max[1])
+}
+
+// sideWall is a horizon in the plane x=1, facing a camera at the origin, and
+// running along z from -5 to 1, past the camera.
+var sideWall = Quad{
+	glm.Vec4d{1, 0, -2, 1},
+	glm.Vec4d{1, 0, 0, 0},
+	glm.Vec4d{0, 0, -1, 0},
+	glm.Vec4d{3, 1, 1, 0},
+	Rectangle{},
+}
+
+func TestScreenRectStraddlingNearPlane(t *testing.T) {
+	// a 90 degree view, so a point (x, y, -z) lands on (x/z, y/z)
+	projection := glm.Perspectived(90, 1, 0.1, 100)
+	r, ok := sideWall.ScreenRect(projection)
+	if !ok {
+		t.Fatal("horizon straddling the near plane is not on screen")
+	}
+	// from the far edge at z=-5 to where the horizon meets the near plane
+	want := Rect{glm.Vec2d{0.2, -10}, glm.Vec2d{10, 10}}
+	if !approxRect(r, want) {
+		t.Errorf("screen rect %v, want %v", r, want)
+	}
+}
+
+func TestScreenRectBehind(t *testing.T) {
+	projection := glm.Perspectived(90, 1, 0.1, 100)
+	q := sideWall
+	q.Center = glm.Vec4d{1, 0, 5, 1}
+	if r, ok := q.ScreenRect(projection); ok {
+		t.Errorf("horizon behind the camera is on screen at %v", r)
+	}
+	q.Center = glm.Vec4d{1, 0, -10, 1}
+	r, ok := q.ScreenRect(projection)
+	want := Rect{glm.Vec2d{1.0 / 13, -1.0 / 7}, glm.Vec2d{1.0 / 7, 1.0 / 7}}
+	if !ok || !approxRect(r, want) {
+		t.Errorf("screen rect %v, want %v", r, want)
+	}
+}
+
+func TestVisibleStraddling(t *testing.T) {
+	projection := glm.Perspectived(90, 1, 0.1, 100)
+	p := NewPortal(sideWall, sideWall)
+	r, ok := p.Visible(projection, glm.Ident4d(), FullScreen)
+	if !ok {
+		t.Fatal("portal straddling the camera is culled")
+	}
+	want := Rect{glm.Vec2d{0.2, -1}, glm.Vec2d{1, 1}}
+	if !approxRect(r, want) {
+		t.Errorf("visible rect %v, want %v", r, want)
+	}
+	// seen from behind it faces away
+	if _, ok := p.Visible(projection, glm.Translate3Dd(-2, 0, 0), FullScreen); ok {
+		t.Error("portal facing away is visible")
+	}
+	// off to the left of the parent view
+	left := Rect{glm.Vec2d{-1, -1}, glm.Vec2d{0, 1}}
+	if _, ok := p.Visible(projection, glm.Ident4d(), left); ok {
+		t.Error("portal outside its parent's rect is visible")
+	}
+}
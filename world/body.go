@@ -0,0 +1,297 @@
+package world
+
+import (
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+type BodyShape int
+
+const (
+	BoxBody BodyShape = iota
+	SphereBody
+)
+
+// bodyRestitution is the fraction of closing speed kept when bodies bounce
+// off each other, the player or the level.
+const bodyRestitution = 0.3
+
+// restingSpeed is the speed into a surface below which bodies stop rather
+// than bounce, so they come to rest.
+const restingSpeed = 1.0
+
+// bodyRollDamping is the fraction of spin lost per second on the ground.
+const bodyRollDamping = 0.8
+
+// Body is a rigid object moving under gravity. Boxes collide with the level
+// as the sphere inscribed in them, so they can rest on a face but not tip.
+type Body struct {
+	Name            string
+	Shape           BodyShape
+	HalfExtents     glm.Vec4d // in the body's own axes, at size 1
+	Position        glm.Vec4d
+	Velocity        glm.Vec4d
+	Orientation     glm.Quatd
+	AngularVelocity glm.Vec4d // axis times radians per second
+	Size            float64
+	Base            glm.Mat4d // the model's rotation and scale as loaded
+	Model           *gtk.Model
+}
+
+// NewBody places a model at the translation of transform, keeping the rest of
+// transform as its base. The extents come from the model's triangles.
+func NewBody(name string, shape BodyShape, model *gtk.Model, transform glm.Mat4d, triangles []portal.Triangle) *Body {
+	base := transform
+	base[12], base[13], base[14] = 0, 0, 0
+	bounds := portal.EmptyAABB()
+	for _, t := range triangles {
+		placed := t.Apply(base)
+		bounds = bounds.Union(placed.Bounds())
+	}
+	half := glm.Vec4d{0.5, 0.5, 0.5, 0}
+	if len(triangles) > 0 {
+		half = bounds.Max.Sub(bounds.Min).Mul(0.5)
+		half[3] = 0
+	}
+	b := &Body{
+		name,
+		shape,
+		half,
+		glm.Vec4d{transform[12], transform[13], transform[14], 1},
+		glm.Vec4d{},
+		glm.QuatIdentd(),
+		glm.Vec4d{},
+		1,
+		base,
+		model,
+	}
+	b.UpdateModel()
+	return b
+}
+
+// Radius is the radius the body collides with.
+func (b *Body) Radius() float64 {
+	h := b.HalfExtents
+	if b.Shape == SphereBody {
+		return math.Max(h[0], math.Max(h[1], h[2])) * b.Size
+	}
+	return math.Min(h[0], math.Min(h[1], h[2])) * b.Size
+}
+
+// Reach is the radius of a sphere enclosing the body.
+func (b *Body) Reach() float64 {
+	if b.Shape == SphereBody {
+		return b.Radius()
+	}
+	return b.HalfExtents.Len() * b.Size
+}
+
+func (b *Body) Capsule() portal.Capsule {
+	return portal.Capsule{b.Position, b.Position, b.Radius()}
+}
+
+// Transform maps the model into world space.
+func (b *Body) Transform() glm.Mat4d {
+	p := b.Position
+	s := b.Size
+	return glm.Translate3Dd(p[0], p[1], p[2]).Mul4(b.Orientation.Mat4()).Mul4(glm.Scale3Dd(s, s, s)).Mul4(b.Base)
+}
+
+func (b *Body) UpdateModel() {
+	if b.Model != nil {
+		b.Model.Transform = b.Transform()
+	}
+}
+
+// Cross carries the body through a portal.
+func (b *Body) Cross(g portal.Passage) {
+	b.Position = g.Point(b.Position)
+	b.Velocity = g.Vector(b.Velocity)
+	w := g.Rotation.Rotate(glm.Vec3d{b.AngularVelocity[0], b.AngularVelocity[1], b.AngularVelocity[2]})
+	b.AngularVelocity = glm.Vec4d{w[0], w[1], w[2], 0}
+	b.Orientation = g.Rotation.Mul(b.Orientation).Normalize()
+	b.Size *= g.Size()
+}
+
+// spin turns the orientation by the angular velocity over deltaT.
+func (b *Body) spin(deltaT float64) {
+	w := glm.Vec3d{b.AngularVelocity[0], b.AngularVelocity[1], b.AngularVelocity[2]}
+	angle := w.Len() * deltaT
+	if angle < 1e-12 {
+		return
+	}
+	turn := glm.Quatd{math.Cos(angle / 2), w.Normalize().Mul(math.Sin(angle / 2))}
+	b.Orientation = turn.Mul(b.Orientation).Normalize()
+}
+
+// bounce removes the part of v heading into a surface with the given normal,
+// sending back a fraction of it when it hits faster than restingSpeed.
+func bounce(v, normal glm.Vec4d) glm.Vec4d {
+	into := v.Dot(normal)
+	if into >= 0 {
+		return v
+	}
+	if -into < restingSpeed {
+		return v.Sub(normal.Mul(into))
+	}
+	return v.Sub(normal.Mul(into * (1 + bodyRestitution)))
+}
+
+// Crossed finds the portal whose horizon a point moving by move passes
+// through from the front.
+func (w *World) Crossed(position, move glm.Vec4d) (int, bool) {
+	for i, p := range w.Level.Portals {
+		pos := p.Portalview.Mul4x1(position)
+		v := p.Portalview.Mul4x1(move)
+		if pos[2] < 0 && v[2] > 0 {
+			t := -pos[2] / v[2]
+			hit := pos.Add(v.Mul(t))
+			if p.EventHorizon.Contains(hit[0], hit[1]) && t > 0 && t <= 1 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Straddling lists the portals a sphere overlaps the opening of.
+func (w *World) Straddling(center glm.Vec4d, radius float64) []int {
+	indices := []int{}
+	for i, p := range w.Level.Portals {
+		local := p.Portalview.Mul4x1(center)
+		if math.Abs(local[2]*p.EventHorizon.Scale[2]) < radius && p.EventHorizon.Contains(local[0], local[1]) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// StepBodies moves every body by one tick, through portals and against the
+// level, the player and each other.
+func (w *World) StepBodies(deltaT float64) {
+	for _, b := range w.Bodies {
+		w.stepBody(b, deltaT)
+	}
+	w.separateBodies()
+	for _, b := range w.Bodies {
+		b.UpdateModel()
+	}
+}
+
+func (w *World) stepBody(b *Body, deltaT float64) {
+	b.Velocity = b.Velocity.Add(w.GravityAt(b.Position).Mul(deltaT))
+	dp := b.Velocity.Mul(deltaT)
+	if i, ok := w.Crossed(b.Position, dp); ok {
+		passage := w.Level.Portals[i].Passage()
+		b.Cross(passage)
+		dp = passage.Vector(dp)
+	}
+
+	moved, normals := w.Level.Sweep(b.Capsule(), dp)
+	b.Position = b.Position.Add(moved)
+
+	// the part of the body through a portal collides on the far side
+	for _, i := range w.Straddling(b.Position, b.Reach()) {
+		passage := w.Level.Portals[i].Passage()
+		ghost := portal.Capsule{passage.Point(b.Position), passage.Point(b.Position), b.Radius() * passage.Size()}
+		back := passage.Rotation.Conjugate()
+		for _, contact := range w.Level.Contacts(ghost) {
+			n := back.Rotate(glm.Vec3d{contact.Normal[0], contact.Normal[1], contact.Normal[2]})
+			normal := glm.Vec4d{n[0], n[1], n[2], 0}
+			b.Position = b.Position.Add(normal.Mul(contact.Depth / passage.Size()))
+			normals = append(normals, normal)
+		}
+	}
+
+	grounded := false
+	up := w.UpAt(b.Position)
+	for _, n := range normals {
+		b.Velocity = bounce(b.Velocity, n)
+		if n.Dot(up) > 0.7 {
+			grounded = true
+		}
+	}
+	if grounded {
+		// roll along the ground without slipping
+		r := b.Radius()
+		if b.Shape == SphereBody && r > 0 {
+			w3 := gtk.ToVec3D(up).Cross(gtk.ToVec3D(b.Velocity)).Mul(1 / r)
+			b.AngularVelocity = glm.Vec4d{w3[0], w3[1], w3[2], 0}
+		} else {
+			b.AngularVelocity = b.AngularVelocity.Mul(math.Max(0, 1-bodyRollDamping*deltaT))
+		}
+	}
+	b.spin(deltaT)
+}
+
+// separateBodies pushes bodies out of the player, who does not give way, and
+// out of each other, sharing the push.
+func (w *World) separateBodies() {
+	player := w.Player.Capsule(w.Constants)
+	for _, b := range w.Bodies {
+		_, onPlayer := portal.ClosestSegments(b.Position, b.Position, player.A, player.B)
+		w.separate(b, nil, onPlayer, player.Radius)
+	}
+	for i, a := range w.Bodies {
+		for _, b := range w.Bodies[i+1:] {
+			w.separate(a, b, b.Position, b.Radius())
+		}
+	}
+}
+
+// separate pushes body a out of a sphere at center, which is body b if b is
+// not nil, and bounces their velocities off each other.
+func (w *World) separate(a, b *Body, center glm.Vec4d, radius float64) {
+	d := a.Position.Sub(center)
+	d[3] = 0
+	dist := d.Len()
+	depth := a.Radius() + radius - dist
+	if depth <= 0 || dist < 1e-9 {
+		return
+	}
+	normal := d.Mul(1 / dist)
+	if b == nil {
+		a.Position = a.Position.Add(normal.Mul(depth))
+		a.Velocity = bounce(a.Velocity, normal)
+		return
+	}
+	a.Position = a.Position.Add(normal.Mul(depth / 2))
+	b.Position = b.Position.Sub(normal.Mul(depth / 2))
+	closing := a.Velocity.Sub(b.Velocity).Dot(normal)
+	if closing < 0 {
+		impulse := normal.Mul(closing * (1 + bodyRestitution) / 2)
+		a.Velocity = a.Velocity.Sub(impulse)
+		b.Velocity = b.Velocity.Add(impulse)
+	}
+}
+
+// Instance is a model to draw with View applied before its own transform.
+type Instance struct {
+	Model *gtk.Model
+	View  glm.Mat4d
+}
+
+// Instances lists the bodies to draw, with a second copy of each body
+// straddling a portal drawn on the far side of it.
+func (w *World) Instances() []Instance {
+	instances := []Instance{}
+	for _, b := range w.Bodies {
+		if b.Model == nil {
+			continue
+		}
+		instances = append(instances, Instance{b.Model, glm.Ident4d()})
+		for _, i := range w.Straddling(b.Position, b.Reach()) {
+			passage := w.Level.Portals[i].Passage()
+			p, g := b.Position, passage.Point(b.Position)
+			s := passage.Size()
+			view := glm.Translate3Dd(g[0], g[1], g[2]).
+				Mul4(passage.Rotation.Mat4()).
+				Mul4(glm.Scale3Dd(s, s, s)).
+				Mul4(glm.Translate3Dd(-p[0], -p[1], -p[2]))
+			instances = append(instances, Instance{b.Model, view})
+		}
+	}
+	return instances
+}
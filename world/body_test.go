@@ -0,0 +1,226 @@
+package world
+
+import (
+	"github.com/GlenKelley/go-collada"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+// ball is a sphere body of radius 0.5 with a model to draw.
+func ball(position glm.Vec4d) *Body {
+	b := NewBody("ball", SphereBody, gtk.EmptyModel("ball"), glm.Ident4d(), nil)
+	b.Position = position
+	return b
+}
+
+// passageWorld has no gravity and no floor, only a portal facing the origin
+// at z=-1 that leads out of one off to the side, exit times its size.
+func passageWorld(exit float64, transit portal.Transit) *World {
+	w := New(DefaultConstants)
+	w.Constants.Gravity = 0
+	entry := UnitQuad
+	entry.Center = glm.Vec4d{0, 1, -1, 1}
+	entry.Normal = glm.Vec4d{0, 0, -1, 0}
+	entry.PlaneV = glm.Vec4d{-1, 0, 0, 0}
+	side := UnitQuad
+	side.Center = glm.Vec4d{10, 1, 0, 1}
+	side.Normal = glm.Vec4d{1, 0, 0, 0}
+	side.PlaneV = glm.Vec4d{0, 0, -1, 0}
+	side.Scale = glm.Vec4d{exit, exit, 1, 0}
+	w.Network.Horizons[1] = entry
+	w.Network.Horizons[2] = side
+	w.Network.Link(1, 2)
+	w.Network.Transits[1] = transit
+	w.Network.Transits[2] = transit
+	w.RebuildPortals()
+	w.Player = NewPlayer(glm.Vec4d{0, 1, 20, 1})
+	return w
+}
+
+func TestBodyFallsToRest(t *testing.T) {
+	for _, shape := range []BodyShape{BoxBody, SphereBody} {
+		w := floorWorld()
+		b := NewBody("crate", shape, gtk.EmptyModel("crate"), glm.Translate3Dd(5, 3, 0), nil)
+		w.Bodies = []*Body{b}
+		for i := 0; i < 240; i++ {
+			w.Step()
+			if b.Position[1] < b.Radius()-1e-6 {
+				t.Fatalf("shape %d: tick %d: body sank to %v", shape, w.Tick, b.Position)
+			}
+		}
+		if h := b.Position[1]; math.Abs(h-b.Radius()) > groundSkin {
+			t.Errorf("shape %d: body came to rest at height %v, want %v", shape, h, b.Radius())
+		}
+		if !b.Velocity.ApproxEqual(glm.Vec4d{}) {
+			t.Errorf("shape %d: resting body has velocity %v", shape, b.Velocity)
+		}
+		if b.Position[0] != 5 || b.Position[2] != 0 {
+			t.Errorf("shape %d: body drifted to %v falling straight down", shape, b.Position)
+		}
+		if !b.Model.Transform.Mul4x1(glm.Vec4d{0, 0, 0, 1}).ApproxEqual(b.Position) {
+			t.Errorf("shape %d: model left at %v", shape, b.Model.Transform)
+		}
+	}
+}
+
+// Through an exit twice the size of the entry, a body keeps its size and speed
+// or doubles both, by the portal's transit.
+func TestBodyCrossesMismatchedPortals(t *testing.T) {
+	for _, c := range []struct {
+		transit     portal.Transit
+		size, speed float64
+	}{
+		{portal.PreserveSpeed, 1, 5},
+		{portal.ScaleWithPortal, 2, 10},
+	} {
+		w := passageWorld(2, c.transit)
+		b := ball(glm.Vec4d{0, 1, 0, 1})
+		b.Velocity = glm.Vec4d{0, 0, -5, 0}
+		w.Bodies = []*Body{b}
+		for i := 0; i < 30 && b.Position[0] < 5; i++ {
+			w.Step()
+		}
+		if b.Position[0] < 5 {
+			t.Fatalf("%v: the body never crossed, it is at %v", c.transit, b.Position)
+		}
+		if math.Abs(b.Size-c.size) > 1e-9 {
+			t.Errorf("%v: body size %v, want %v", c.transit, b.Size, c.size)
+		}
+		if !b.Velocity.ApproxEqual(glm.Vec4d{-c.speed, 0, 0, 0}) {
+			t.Errorf("%v: body leaves at %v, want %v along -x", c.transit, b.Velocity, c.speed)
+		}
+		if b.Position[0] > 10 {
+			t.Errorf("%v: body came out behind the exit at %v", c.transit, b.Position)
+		}
+	}
+}
+
+// A body half through a portal collides, on the near side, with what its far
+// side touches beyond the exit.
+func TestBodyStraddlingCollidesBeyondPortal(t *testing.T) {
+	w := passageWorld(1, portal.PreserveSpeed)
+	// a wall across the exit, out of its opening 1.1 from it, as one
+	// triangle so that there is one contact
+	a := glm.Vec4d{8.9, -10, -10, 1}
+	b := glm.Vec4d{8.9, 30, -10, 1}
+	c := glm.Vec4d{8.9, -10, 30, 1}
+	w.Level.AddGroup("wall", []portal.Triangle{{a, b, c, "wall"}})
+	w.Level.Build()
+
+	// a ball of radius 1.5, its far half reaching 0.4 into the wall
+	body := ball(glm.Vec4d{0, 1, -1, 1})
+	body.Size = 3
+	body.Velocity = glm.Vec4d{0, 0, -0.5, 0}
+	w.Bodies = []*Body{body}
+	w.Step()
+	// the wall pushes back along +z
+	if z := body.Position[2]; math.Abs(z-(-0.6)) > 1e-6 {
+		t.Errorf("straddling body at z=%v, want pushed back to -0.6", z)
+	}
+	if body.Velocity[2] < 0 {
+		t.Errorf("straddling body still moves into the far wall at %v", body.Velocity)
+	}
+
+	// without the wall nothing is in the way
+	w = passageWorld(1, portal.PreserveSpeed)
+	body = ball(glm.Vec4d{0, 1, -1, 1})
+	body.Size = 3
+	w.Bodies = []*Body{body}
+	w.Step()
+	if !body.Position.ApproxEqual(glm.Vec4d{0, 1, -1, 1}) {
+		t.Errorf("straddling body moved to %v with nothing beyond the exit", body.Position)
+	}
+}
+
+func TestInstancesStraddling(t *testing.T) {
+	w := passageWorld(2, portal.ScaleWithPortal)
+	near := ball(glm.Vec4d{0, 1, 3, 1})
+	straddling := ball(glm.Vec4d{0.2, 1.1, -1.1, 1})
+	hidden := ball(glm.Vec4d{0, 1, -1, 1})
+	hidden.Model = nil
+	w.Bodies = []*Body{near, straddling, hidden}
+	instances := w.Instances()
+	if len(instances) != 3 {
+		t.Fatalf("%d instances, want the near body and two copies of the straddling one", len(instances))
+	}
+	for i, body := range []*Body{near, straddling, straddling} {
+		if instances[i].Model != body.Model {
+			t.Errorf("instance %d draws %v, want %v", i, instances[i].Model.Name, body.Model.Name)
+		}
+	}
+	if instances[0].View != glm.Ident4d() || instances[1].View != glm.Ident4d() {
+		t.Error("bodies are not drawn where they are")
+	}
+	// the copy is the body carried through the portal, at the exit's scale
+	passage := w.Level.Portals[0].Passage()
+	view := instances[2].View
+	if p := view.Mul4x1(straddling.Position); !p.ApproxEqual(passage.Point(straddling.Position)) {
+		t.Errorf("far copy centered at %v, want %v", p, passage.Point(straddling.Position))
+	}
+	if x := view.Mul4x1(glm.Vec4d{0, 0, -1, 0}); !x.ApproxEqual(glm.Vec4d{-2, 0, 0, 0}) {
+		t.Errorf("far copy turns -z into %v, want doubled along -x", x)
+	}
+}
+
+func TestImportBodies(t *testing.T) {
+	w := New(DefaultConstants)
+	l := w.newLevelLoader(nil)
+	cube := []portal.Triangle{
+		{glm.Vec4d{-1, -1, -1, 1}, glm.Vec4d{1, -1, -1, 1}, glm.Vec4d{1, 1, 1, 1}, "cube"},
+	}
+	l.meshes["cube-mesh"] = meshTemplate{[]*gtk.Geometry{{}}, cube, glm.Ident4d()}
+	geometry := []*collada.InstanceGeometry{{Url: collada.Uri("#cube-mesh")}}
+	scene := []*collada.Node{{
+		Name:             "Cube_crate",
+		Translate:        []*collada.Translate{translate("1", "2", "3")},
+		Scale:            []*collada.Scale{scale("2 1 1")},
+		InstanceGeometry: geometry,
+		Node:             []*collada.Node{{Name: "Lid"}},
+	}, {
+		Name:      "Sphere_ball",
+		Translate: []*collada.Translate{translate("-4", "1", "0")},
+	}, {
+		Name:             "Crate",
+		InstanceGeometry: geometry,
+	}}
+	d := &sceneDocument{map[collada.Id]*collada.Node{}, map[collada.Id]bool{}}
+	for _, node := range scene {
+		l.importNode(d, node, l.model, glm.Ident4d())
+	}
+	if len(w.Bodies) != 2 {
+		t.Fatalf("%d bodies, want the cube and the sphere", len(w.Bodies))
+	}
+	crate, ball := w.Bodies[0], w.Bodies[1]
+	if crate.Name != "Cube_crate" || crate.Shape != BoxBody || ball.Name != "Sphere_ball" || ball.Shape != SphereBody {
+		t.Errorf("bodies %s (%v) and %s (%v), want a box and a sphere", crate.Name, crate.Shape, ball.Name, ball.Shape)
+	}
+	if !crate.Position.ApproxEqual(glm.Vec4d{1, 2, 3, 1}) || !ball.Position.ApproxEqual(glm.Vec4d{-4, 1, 0, 1}) {
+		t.Errorf("bodies at %v and %v, want at their nodes' translations", crate.Position, ball.Position)
+	}
+	// the scale stays in the base, and the extents are of the scaled mesh
+	if !crate.HalfExtents.ApproxEqual(glm.Vec4d{2, 1, 1, 0}) {
+		t.Errorf("crate half extents %v, want (2, 1, 1)", crate.HalfExtents)
+	}
+	if !ball.HalfExtents.ApproxEqual(glm.Vec4d{0.5, 0.5, 0.5, 0}) {
+		t.Errorf("ball without a mesh has half extents %v, want 0.5", ball.HalfExtents)
+	}
+	if !crate.Model.Transform.Mul4x1(glm.Vec4d{1, 1, 1, 1}).ApproxEqual(glm.Vec4d{3, 3, 4, 1}) {
+		t.Errorf("crate model transform %v", crate.Model.Transform)
+	}
+	if len(crate.Model.Geometry) != 1 {
+		t.Errorf("crate model has %d geometries, want its mesh", len(crate.Model.Geometry))
+	}
+	// bodies are neither drawn with the level nor collided with as part of it
+	if len(l.model.Children) != 1 || l.model.Children[0].Name != "Crate" {
+		t.Errorf("level models %v, want only the plain crate", l.model.Children)
+	}
+	if _, ok := w.Colliders["Cube_crate"]; ok || len(w.Colliders) != 1 {
+		t.Errorf("colliders %v, want only the plain crate", w.Colliders)
+	}
+	if len(l.problems) != 1 || l.problems[0].Node != "Cube_crate" {
+		t.Errorf("problems %v, want one for the crate's ignored child", l.problems)
+	}
+}
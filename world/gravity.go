@@ -0,0 +1,138 @@
+package world
+
+import (
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+)
+
+// GravityRegion sets the gravity inside a box of the level.
+type GravityRegion struct {
+	Bounds  portal.AABB
+	Gravity glm.Vec4d
+}
+
+// DefaultGravity pulls along Y by Constants.Gravity.
+func (w *World) DefaultGravity() glm.Vec4d {
+	return glm.Vec4d{0, w.Constants.Gravity, 0, 0}
+}
+
+// GravityAt is the gravity of the last region containing p, or the default
+// outside of them all.
+func (w *World) GravityAt(p glm.Vec4d) glm.Vec4d {
+	for i := len(w.Gravity) - 1; i >= 0; i-- {
+		if w.Gravity[i].Bounds.Contains(p) {
+			return w.Gravity[i].Gravity
+		}
+	}
+	return w.DefaultGravity()
+}
+
+// UpAt is the direction against gravity at p, Y where there is none.
+func (w *World) UpAt(p glm.Vec4d) glm.Vec4d {
+	g := w.GravityAt(p)
+	if g.Len() < 1e-9 {
+		return glm.Vec4d{0, 1, 0, 0}
+	}
+	return g.Normalize().Mul(-1)
+}
+
+// Upright is the orientation looking the same way as q with no roll about
+// up. Looking straight along up it tips q the shortest way instead.
+func Upright(q glm.Quatd, up glm.Vec4d) glm.Quatd {
+	u := glm.Vec3d{up[0], up[1], up[2]}
+	forward := q.Rotate(glm.Vec3d{0, 0, -1})
+	right := forward.Cross(u)
+	if right.Len() < 1e-6 {
+		arc, err := portal.ShortestArc(portal4(q.Rotate(glm.Vec3d{0, 1, 0})), up, glm.Vec3d{})
+		if err != nil {
+			return q
+		}
+		return arc.Mul(q)
+	}
+	right = right.Normalize()
+	return frameRotation(right, right.Cross(forward), forward.Mul(-1))
+}
+
+// Heading is the orientation turned about up only, facing the way q faces
+// across the ground. It is false when q looks along up.
+func Heading(q glm.Quatd, up glm.Vec4d) (glm.Quatd, bool) {
+	u := glm.Vec3d{up[0], up[1], up[2]}
+	forward := q.Rotate(glm.Vec3d{0, 0, -1})
+	forward = forward.Sub(u.Mul(forward.Dot(u)))
+	if forward.Len() < 1e-6 {
+		return q, false
+	}
+	back := forward.Normalize().Mul(-1)
+	return frameRotation(u.Cross(back), u, back), true
+}
+
+// frameRotation is the rotation taking the coordinate axes onto x, y, z.
+func frameRotation(x, y, z glm.Vec3d) glm.Quatd {
+	axes := portal.PortalFrame{
+		X: glm.Vec4d{1, 0, 0, 0},
+		Y: glm.Vec4d{0, 1, 0, 0},
+		Z: glm.Vec4d{0, 0, 1, 0},
+	}
+	return axes.Rotation(portal.PortalFrame{X: portal4(x), Y: portal4(y), Z: portal4(z)})
+}
+
+func portal4(v glm.Vec3d) glm.Vec4d {
+	return glm.Vec4d{v[0], v[1], v[2], 0}
+}
+
+// slerp turns a toward b by the fraction t along the shorter arc.
+func slerp(a, b glm.Quatd, t float64) glm.Quatd {
+	dot := a.W*b.W + a.V.Dot(b.V)
+	if dot < 0 {
+		b = glm.Quatd{-b.W, b.V.Mul(-1)}
+		dot = -dot
+	}
+	if dot > 0.9995 {
+		return glm.Quatd{a.W + (b.W-a.W)*t, a.V.Add(b.V.Sub(a.V).Mul(t))}.Normalize()
+	}
+	theta := math.Acos(dot)
+	sa := math.Sin((1-t)*theta) / math.Sin(theta)
+	sb := math.Sin(t*theta) / math.Sin(theta)
+	return glm.Quatd{a.W*sa + b.W*sb, a.V.Mul(sa).Add(b.V.Mul(sb))}
+}
+
+// Reorient points the pan axis against gravity at the player, and turns the
+// view upright over Constants.UprightTime once the player is rolled, after a
+// portal crossing or a change of gravity.
+func (w *World) Reorient(deltaT float64) {
+	p := &w.Player
+	up := w.UpAt(p.Position)
+	if up.Dot(p.PanAxis) < 1-1e-9 {
+		p.PanAxis = up
+		p.TiltAxis = TiltAxis(p.Orientation, up, p.TiltAxis)
+		p.Righting = w.Constants.UprightTime
+	}
+	if p.Righting <= 0 {
+		return
+	}
+	k := 1.0
+	if p.Righting > deltaT {
+		k = deltaT / p.Righting
+	}
+	p.Righting -= deltaT
+	p.Orientation = slerp(p.Orientation, Upright(p.Orientation, up), k)
+	p.TiltAxis = TiltAxis(p.Orientation, up, p.TiltAxis)
+	if h, ok := Heading(p.Orientation, up); ok {
+		p.OrientationH = h
+	}
+}
+
+// TiltAxis is the axis to look up and down about, in the player's own frame:
+// their right, made orthogonal to up as the player sees it, so a rolled view
+// still tilts toward up. It is the player's X axis once upright, and previous
+// when up lies along the player's right.
+func TiltAxis(orientation glm.Quatd, up, previous glm.Vec4d) glm.Vec4d {
+	u := orientation.Conjugate().Rotate(glm.Vec3d{up[0], up[1], up[2]})
+	right := glm.Vec3d{1, 0, 0}
+	right = right.Sub(u.Mul(right.Dot(u)))
+	if right.Len() < 1e-6 {
+		return previous
+	}
+	return portal4(right.Normalize())
+}
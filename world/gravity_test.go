@@ -0,0 +1,121 @@
+package world
+
+import (
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"testing"
+)
+
+// checkTilt checks the tilt axis is a unit vector across up as the player
+// sees it.
+func checkTilt(t *testing.T, p Player, up glm.Vec4d) {
+	tilt := glm.Vec3d{p.TiltAxis[0], p.TiltAxis[1], p.TiltAxis[2]}
+	u := p.Orientation.Conjugate().Rotate(glm.Vec3d{up[0], up[1], up[2]})
+	if math.Abs(tilt.Len()-1) > 1e-9 || math.Abs(tilt.Dot(u)) > 1e-9 {
+		t.Fatalf("tilt axis %v is not a unit vector across up %v", tilt, u)
+	}
+}
+
+func TestReorientTiltAxis(t *testing.T) {
+	w := New(DefaultConstants)
+	w.SpawnPlayer()
+	checkTilt(t, w.Player, glm.Vec4d{0, 1, 0, 0})
+	// gravity turns to pull along -x, and the player rights themselves
+	everywhere := portal.AABB{glm.Vec4d{-100, -100, -100, 1}, glm.Vec4d{100, 100, 100, 1}}
+	w.Gravity = []GravityRegion{{everywhere, glm.Vec4d{-9.8, 0, 0, 0}}}
+	up := glm.Vec4d{1, 0, 0, 0}
+	for w.Elapsed() < 2*w.Constants.UprightTime {
+		w.Step()
+		if w.Player.PanAxis != up {
+			t.Fatalf("pan axis %v, want %v", w.Player.PanAxis, up)
+		}
+		checkTilt(t, w.Player, up)
+	}
+	if !w.Player.TiltAxis.ApproxEqual(glm.Vec4d{1, 0, 0, 0}) {
+		t.Errorf("upright player tilts about %v, want their X axis", w.Player.TiltAxis)
+	}
+	// looking up and down keeps the view level across the new up
+	w.Pan(glm.Vec2d{0, 0.1})
+	right := w.Player.Orientation.Rotate(glm.Vec3d{1, 0, 0})
+	if math.Abs(right.Dot(glm.Vec3d{1, 0, 0})) > 1e-9 {
+		t.Errorf("tilting rolled the view, its right is %v", right)
+	}
+}
+
+func TestTiltAxisRolled(t *testing.T) {
+	// rolled a quarter turn about the view, up lies along the player's right
+	rolled := glm.QuatRotated(90, glm.Vec3d{0, 0, 1})
+	previous := glm.Vec4d{0, 0, 1, 0}
+	if tilt := TiltAxis(rolled, glm.Vec4d{0, 1, 0, 0}, previous); tilt != previous {
+		t.Errorf("tilt axis %v, want the previous %v", tilt, previous)
+	}
+	// rolled less, the tilt axis leans away from up
+	rolled = glm.QuatRotated(30, glm.Vec3d{0, 0, 1})
+	tilt := TiltAxis(rolled, glm.Vec4d{0, 1, 0, 0}, previous)
+	world := rolled.Rotate(glm.Vec3d{tilt[0], tilt[1], tilt[2]})
+	if math.Abs(world[1]) > 1e-9 {
+		t.Errorf("tilt axis %v is not level, in the world it is %v", tilt, world)
+	}
+}
+
+// roll is how far the player's right leans out of level, in degrees.
+func roll(p Player, up glm.Vec4d) float64 {
+	right := p.Orientation.Rotate(glm.Vec3d{1, 0, 0})
+	return math.Asin(right.Dot(glm.Vec3d{up[0], up[1], up[2]})) * 180 / math.Pi
+}
+
+// Coming up out of a floor portal from a wall portal, a player who entered at
+// an angle is rolled, and rights themselves over UprightTime.
+func TestUprightAfterWallToFloor(t *testing.T) {
+	w := New(DefaultConstants)
+	wall := UnitQuad
+	wall.Center = glm.Vec4d{0, 1, -1, 1}
+	wall.Normal = glm.Vec4d{0, 0, -1, 0}
+	wall.PlaneV = glm.Vec4d{-1, 0, 0, 0}
+	floor := UnitQuad
+	floor.Center = glm.Vec4d{10, 0, 0, 1}
+	floor.Normal = glm.Vec4d{0, -1, 0, 0}
+	floor.PlaneV = glm.Vec4d{1, 0, 0, 0}
+	w.Network.Horizons[1] = wall
+	w.Network.Horizons[2] = floor
+	w.Network.Link(1, 2)
+	w.RebuildPortals()
+	crossed := -1
+	w.OnCross = func(c Crossing) { crossed = c.Tick }
+
+	w.Player = NewPlayer(glm.Vec4d{0, 1, 0, 1})
+	w.Player.Orientation = glm.QuatRotated(30, glm.Vec3d{0, 1, 0})
+	w.Player.OrientationH = w.Player.Orientation
+	w.Player.Velocity = glm.Vec4d{0, 0, -5, 0}
+	up := glm.Vec4d{0, 1, 0, 0}
+	for crossed < 0 {
+		if w.Tick > 60 {
+			t.Fatal("the player never reaches the wall portal")
+		}
+		w.Step()
+	}
+	if w.Player.Position[0] < 9 {
+		t.Fatalf("crossed to %v, not out of the floor portal", w.Player.Position)
+	}
+	last := math.Abs(roll(w.Player, up))
+	if last < 1 {
+		t.Fatalf("the crossing left the player rolled only %v degrees", last)
+	}
+	ticks := int(math.Ceil(w.Constants.UprightTime / w.Constants.TimeStep))
+	for i := 0; i < ticks; i++ {
+		w.Step()
+		checkTilt(t, w.Player, up)
+		r := math.Abs(roll(w.Player, up))
+		if r > last+1e-9 {
+			t.Fatalf("tick %d: roll grew from %v to %v degrees", w.Tick, last, r)
+		}
+		last = r
+	}
+	if last > 1e-6 {
+		t.Errorf("still rolled %v degrees after %v seconds", last, w.Constants.UprightTime)
+	}
+	if w.Player.Righting > 0 {
+		t.Errorf("%v seconds of righting left", w.Player.Righting)
+	}
+}
@@ -0,0 +1,160 @@
+package world
+
+import (
+	"encoding/json"
+	"fmt"
+	glm "github.com/Jragonmiris/mathgl"
+	"io"
+	"os"
+)
+
+// InputEvent is a command applied at the start of simulation tick Tick.
+type InputEvent struct {
+	Tick    int       `json:"tick"`
+	Command string    `json:"command"`
+	Delta   glm.Vec2d `json:"delta"`
+}
+
+// InputLog holds the events waiting for their tick and the file they are
+// recorded to. A replay only reproduces a run given the same constants and level.
+type InputLog struct {
+	Pending   []InputEvent
+	Replaying bool
+	record    *os.File
+	encoder   *json.Encoder
+}
+
+// Commands maps each command name to what it does to the world.
+var Commands = map[string]func(w *World, e InputEvent) error{
+	"MoveUp":           func(w *World, e InputEvent) error { w.UIState.Movement[1]++; return nil },
+	"StopMoveUp":       func(w *World, e InputEvent) error { w.UIState.Movement[1]--; return nil },
+	"MoveDown":         func(w *World, e InputEvent) error { w.UIState.Movement[1]--; return nil },
+	"StopMoveDown":     func(w *World, e InputEvent) error { w.UIState.Movement[1]++; return nil },
+	"MoveForward":      func(w *World, e InputEvent) error { w.UIState.Movement[2]--; return nil },
+	"StopMoveForward":  func(w *World, e InputEvent) error { w.UIState.Movement[2]++; return nil },
+	"MoveBackward":     func(w *World, e InputEvent) error { w.UIState.Movement[2]++; return nil },
+	"StopMoveBackward": func(w *World, e InputEvent) error { w.UIState.Movement[2]--; return nil },
+	"StrafeLeft":       func(w *World, e InputEvent) error { w.UIState.Movement[0]--; return nil },
+	"StopStrafeLeft":   func(w *World, e InputEvent) error { w.UIState.Movement[0]++; return nil },
+	"StrafeRight":      func(w *World, e InputEvent) error { w.UIState.Movement[0]++; return nil },
+	"StopStrafeRight":  func(w *World, e InputEvent) error { w.UIState.Movement[0]--; return nil },
+	"Jump":             func(w *World, e InputEvent) error { w.UIState.Impulse[1]++; return nil },
+	"PlacePortalA":     func(w *World, e InputEvent) error { return w.PlacePortal(w.GunPortals[0]) },
+	"PlacePortalB":     func(w *World, e InputEvent) error { return w.PlacePortal(w.GunPortals[1]) },
+	"PanView":          func(w *World, e InputEvent) error { w.Pan(e.Delta); return nil },
+	StepCommand:        func(w *World, e InputEvent) error { return nil },
+}
+
+// StepCommand ends every recorded tick, so a recording holds each tick the
+// run stepped through, with or without input.
+const StepCommand = "Step"
+
+// Replay queues the events of a file in place of live input.
+func (l *InputLog) Replay(filename string) error {
+	events, err := ReadInputEvents(filename)
+	if err != nil {
+		return err
+	}
+	l.Pending = events
+	l.Replaying = true
+	return nil
+}
+
+// Record writes every applied event to a file, and a StepCommand for every
+// tick.
+func (l *InputLog) Record(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	l.Close()
+	l.record = file
+	l.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (l *InputLog) Close() {
+	if l.record != nil {
+		l.record.Close()
+		l.record = nil
+		l.encoder = nil
+	}
+}
+
+// ReadInputEvents reads a file of json input events, one per line, in tick order.
+func ReadInputEvents(filename string) ([]InputEvent, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	events := []InputEvent{}
+	decoder := json.NewDecoder(file)
+	for {
+		var e InputEvent
+		err = decoder.Decode(&e)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: event %d: %v", filename, len(events), err)
+		}
+		if _, ok := Commands[e.Command]; !ok {
+			return nil, fmt.Errorf("%s: event %d: unknown command %q", filename, len(events), e.Command)
+		}
+		if len(events) > 0 && e.Tick < events[len(events)-1].Tick {
+			return nil, fmt.Errorf("%s: event %d: tick %d is out of order", filename, len(events), e.Tick)
+		}
+		events = append(events, e)
+	}
+}
+
+// Issue queues a live command for the next tick. Live input is ignored
+// during a replay.
+func (w *World) Issue(command string, delta glm.Vec2d) {
+	if w.Input.Replaying {
+		return
+	}
+	w.Input.Pending = append(w.Input.Pending, InputEvent{w.Tick, command, delta})
+}
+
+// Ticks is how many ticks the pending events cover, up to the last one.
+func (l *InputLog) Ticks() int {
+	if len(l.Pending) == 0 {
+		return 0
+	}
+	return l.Pending[len(l.Pending)-1].Tick + 1
+}
+
+// ApplyInput applies and records the events due at the current tick.
+func (w *World) ApplyInput() {
+	for len(w.Input.Pending) > 0 && w.Input.Pending[0].Tick <= w.Tick {
+		e := w.Input.Pending[0]
+		w.Input.Pending = w.Input.Pending[1:]
+		e.Tick = w.Tick
+		command, ok := Commands[e.Command]
+		if !ok {
+			w.report(fmt.Errorf("unknown command %q", e.Command))
+			continue
+		}
+		err := command(w, e)
+		if err != nil {
+			w.report(err)
+		}
+		if e.Command != StepCommand {
+			w.recordInput(e)
+		}
+	}
+	w.recordInput(InputEvent{w.Tick, StepCommand, glm.Vec2d{}})
+}
+
+func (w *World) recordInput(e InputEvent) {
+	if w.Input.encoder == nil {
+		return
+	}
+	err := w.Input.encoder.Encode(e)
+	if err != nil {
+		w.report(fmt.Errorf("cannot record input: %v", err))
+		w.Input.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package world
+
+import (
+	glm "github.com/Jragonmiris/mathgl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// frames issues commands between frames of a varying number of ticks, as the
+// game loop's accumulator does, and returns the player's position after every
+// tick.
+func frames(w *World, script map[int][]string, steps []int) []glm.Vec4d {
+	positions := []glm.Vec4d{}
+	for frame, n := range steps {
+		for _, command := range script[frame] {
+			w.Issue(command, glm.Vec2d{0.01, 0.02})
+		}
+		for i := 0; i < n; i++ {
+			w.Step()
+			positions = append(positions, w.Player.Position)
+		}
+	}
+	return positions
+}
+
+// portalWorld is floorWorld with a portal just ahead of the player, leading
+// out of another off to the side.
+func portalWorld() *World {
+	w := floorWorld()
+	ahead := UnitQuad
+	ahead.Center = glm.Vec4d{0, 1, -0.5, 1}
+	ahead.Normal = glm.Vec4d{0, 0, -1, 0}
+	ahead.PlaneV = glm.Vec4d{-1, 0, 0, 0}
+	side := UnitQuad
+	side.Center = glm.Vec4d{10, 1, 0, 1}
+	side.Normal = glm.Vec4d{1, 0, 0, 0}
+	side.PlaneV = glm.Vec4d{0, 0, -1, 0}
+	w.Network.Horizons[1] = ahead
+	w.Network.Horizons[2] = side
+	w.Network.Link(1, 2)
+	w.RebuildPortals()
+	return w
+}
+
+// crossings records the crossings a world makes.
+func crossings(w *World) *[]Crossing {
+	cs := &[]Crossing{}
+	w.OnCross = func(c Crossing) { *cs = append(*cs, c) }
+	return cs
+}
+
+func TestReplayDeterminism(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portal-input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "input.json")
+
+	live := portalWorld()
+	liveCrossings := crossings(live)
+	err = live.Input.Record(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := map[int][]string{
+		1: {"MoveForward", "PanView"},
+		3: {"Jump", "StrafeLeft"},
+		4: {"StopMoveForward"},
+		6: {"StopStrafeLeft", "PanView"},
+	}
+	// frames of no ticks, one tick and several queue input differently
+	steps := []int{2, 0, 3, 8, 1, 0, 5, 4, 7}
+	want := frames(live, script, steps)
+	live.Input.Close()
+
+	events, err := ReadInputEvents(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticks := 0
+	for _, e := range events {
+		if e.Command == StepCommand {
+			if e.Tick != ticks {
+				t.Fatalf("tick %d recorded after tick %d", e.Tick, ticks-1)
+			}
+			ticks++
+		}
+	}
+	if ticks != len(want) {
+		t.Errorf("recorded %d ticks of %d", ticks, len(want))
+	}
+
+	if len(*liveCrossings) == 0 {
+		t.Fatal("the recorded run never crosses the portal")
+	}
+
+	replay := portalWorld()
+	replayCrossings := crossings(replay)
+	err = replay.Input.Replay(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := replay.Input.Ticks(); n != len(want) {
+		t.Errorf("replay covers %d ticks, want %d", n, len(want))
+	}
+	// a replay ignores live input, and stepping it one tick per frame
+	// reproduces the run
+	replay.Issue("Jump", glm.Vec2d{})
+	got := []glm.Vec4d{}
+	for i := 0; i < len(want); i++ {
+		replay.Step()
+		got = append(got, replay.Player.Position)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("tick %d: replay at %v, recorded run at %v", i, got[i], want[i])
+		}
+	}
+	if replay.Player != live.Player {
+		t.Errorf("replay ends as %+v, recorded run as %+v", replay.Player, live.Player)
+	}
+	if replay.Inception != live.Inception {
+		t.Errorf("replay ends with inception %v, recorded run %v", replay.Inception, live.Inception)
+	}
+	if len(*replayCrossings) != len(*liveCrossings) {
+		t.Fatalf("replay crosses %v, recorded run %v", *replayCrossings, *liveCrossings)
+	}
+	for i, c := range *liveCrossings {
+		if (*replayCrossings)[i] != c {
+			t.Errorf("crossing %d: replay %v, recorded run %v", i, (*replayCrossings)[i], c)
+		}
+	}
+}
+
+func TestReadInputEventsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portal-input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"unknown":      `{"tick": 0, "command": "Fly"}`,
+		"out of order": `{"tick": 3, "command": "Jump"}` + "\n" + `{"tick": 2, "command": "Jump"}`,
+		"malformed":    `{"tick": "soon"}`,
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		err = ioutil.WriteFile(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadInputEvents(filename); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
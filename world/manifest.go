@@ -0,0 +1,232 @@
+package world
+
+import (
+	"encoding/json"
+	"fmt"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Manifest describes a level without relying on COLLADA node names. It is
+// read from json such as
+//
+//	{
+//		"meshes": ["room.dae"],
+//		"portals": [
+//			{"id": 1, "link": 2, "center": [0, 1, -5], "normal": [0, 0, 1], "up": [0, 1, 0]},
+//			{"id": 2, "link": 1, "center": [-2, 1, 0], "normal": [1, 0, 0], "up": [0, 1, 0],
+//				"shape": "ellipse", "size": [1, 1], "transit": "scale"}
+//		],
+//		"spawn": {"position": [0, 1, 0], "facing": [0, 0, -1]},
+//		"gravity": [{"min": [5, 0, 5], "max": [10, 5, 10], "gravity": [0, 9.8, 0]}],
+//		"entities": [{"name": "crate", "shape": "box", "mesh": "Cube-mesh", "position": [1, 2, -3]}]
+//	}
+//
+// All positions and directions are in world space. Meshes are COLLADA
+// documents relative to the manifest, loaded with their portal and body node
+// names, so older levels can be listed as they are.
+type Manifest struct {
+	Meshes   []string       `json:"meshes"`
+	Portals  []PortalEntry  `json:"portals"`
+	Spawn    *SpawnEntry    `json:"spawn"`
+	Gravity  []GravityEntry `json:"gravity"`
+	Entities []EntityEntry  `json:"entities"`
+}
+
+// PortalEntry declares a portal horizon. Without a link it is only an exit.
+// Size is the full width and height, the gun's portal size when left out.
+// Up is +Y when left out, or -Z for a portal in a floor or ceiling.
+type PortalEntry struct {
+	Id       int        `json:"id"`
+	Link     *int       `json:"link"`
+	Center   [3]float64 `json:"center"`
+	Normal   [3]float64 `json:"normal"`
+	Up       [3]float64 `json:"up"`
+	Shape    string     `json:"shape"` // rectangle or ellipse
+	Size     [2]float64 `json:"size"`
+	Transit  string     `json:"transit"` // preserve or scale
+	OneWay   bool       `json:"oneWay"`  // the exit is not expected to lead back
+	Disabled bool       `json:"disabled"`
+}
+
+type SpawnEntry struct {
+	Position [3]float64 `json:"position"`
+	Facing   [3]float64 `json:"facing"`
+}
+
+type GravityEntry struct {
+	Min     [3]float64 `json:"min"`
+	Max     [3]float64 `json:"max"`
+	Gravity [3]float64 `json:"gravity"`
+}
+
+// EntityEntry places a body built from a mesh of the level's documents.
+type EntityEntry struct {
+	Name     string     `json:"name"`
+	Shape    string     `json:"shape"` // box or sphere
+	Mesh     string     `json:"mesh"`
+	Position [3]float64 `json:"position"`
+	Size     float64    `json:"size"` // 1 when left out
+}
+
+func ReadManifest(filename string) (Manifest, error) {
+	m := Manifest{}
+	file, err := os.Open(filename)
+	if err != nil {
+		return m, err
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&m)
+	if err != nil {
+		return m, fmt.Errorf("%s: %v", filename, err)
+	}
+	return m, nil
+}
+
+// LoadManifest reads a level manifest and the documents it lists. Entries
+// which cannot be loaded are left out and reported with the level's other
+// problems.
+func (w *World) LoadManifest(filename string, newGeometry GeometryFunc) (*gtk.Model, Problems, error) {
+	m, err := ReadManifest(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	l := w.newLevelLoader(newGeometry)
+	for _, mesh := range m.Meshes {
+		err = l.importScene(filepath.Join(filepath.Dir(filename), mesh))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	l.addPortals(filename, m.Portals)
+	if m.Spawn != nil {
+		w.Spawn = Spawn{point(m.Spawn.Position), direction(m.Spawn.Facing)}
+	}
+	for i, g := range m.Gravity {
+		bounds := portal.AABB{point(g.Min), point(g.Max)}
+		if !(g.Min[0] <= g.Max[0] && g.Min[1] <= g.Max[1] && g.Min[2] <= g.Max[2]) {
+			l.problems.add(Error, filename, -1, "gravity region %d has min %v beyond max %v", i, g.Min, g.Max)
+			continue
+		}
+		w.Gravity = append(w.Gravity, GravityRegion{bounds, direction(g.Gravity)})
+	}
+	for _, e := range m.Entities {
+		l.addEntity(e)
+	}
+	model, problems := l.finish()
+	return model, problems, nil
+}
+
+func (l *levelLoader) addPortals(filename string, entries []PortalEntry) {
+	disabled := make(map[int]bool)
+	for _, e := range entries {
+		disabled[e.Id] = e.Disabled
+	}
+	for _, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		if e.Link != nil && disabled[*e.Link] {
+			l.problems.add(Warning, filename, e.Id, "left out, its exit %d is disabled", *e.Link)
+			continue
+		}
+		horizon, err := e.horizon(l.world.Constants)
+		if err != nil {
+			l.problems.add(Error, filename, e.Id, "%v", err)
+			continue
+		}
+		transit, ok := portal.ParseTransit(e.Transit)
+		if !ok {
+			l.problems.add(Error, filename, e.Id, "unknown transit %q", e.Transit)
+			continue
+		}
+		l.oneWay[e.Id] = e.OneWay
+		l.addPortal(filename, e.Id, horizon, e.Link, transit)
+	}
+}
+
+func (e *PortalEntry) horizon(c Constants) (portal.Quad, error) {
+	var shape portal.Shape
+	switch e.Shape {
+	case "", "rectangle":
+		shape = portal.Rectangle{}
+	case "ellipse":
+		shape = portal.Ellipse{}
+	default:
+		return portal.Quad{}, fmt.Errorf("unknown shape %q", e.Shape)
+	}
+	size := e.Size
+	if size == [2]float64{} {
+		size = [2]float64{c.PortalWidth, c.PortalHeight}
+	}
+	normal := direction(e.Normal)
+	up := direction(e.Up)
+	if normal.Len() < 1e-9 {
+		return portal.Quad{}, fmt.Errorf("no normal")
+	}
+	normal = normal.Normalize()
+	if e.Up == [3]float64{} {
+		up = glm.Vec4d{0, 1, 0, 0}
+		if math.Abs(normal[1]) > 0.99 {
+			up = glm.Vec4d{0, 0, -1, 0}
+		}
+	}
+	up = up.Sub(normal.Mul(up.Dot(normal)))
+	if up.Len() < 1e-6 {
+		return portal.Quad{}, fmt.Errorf("up %v is not across normal %v", e.Up, e.Normal)
+	}
+	up = up.Normalize()
+	return portal.Quad{
+		point(e.Center),
+		normal,
+		portal.Cross3Dv(up, normal),
+		glm.Vec4d{size[0] / 2, size[1] / 2, 1, 0},
+		shape,
+	}, nil
+}
+
+func (l *levelLoader) addEntity(e EntityEntry) {
+	w := l.world
+	template, ok := l.meshes[e.Mesh]
+	if !ok {
+		l.problems.add(Error, e.Name, -1, "no mesh %q", e.Mesh)
+		return
+	}
+	var shape BodyShape
+	switch e.Shape {
+	case "box":
+		shape = BoxBody
+	case "sphere":
+		shape = SphereBody
+	default:
+		l.problems.add(Error, e.Name, -1, "unknown shape %q", e.Shape)
+		return
+	}
+	size := e.Size
+	if size == 0 {
+		size = 1
+	}
+	if size < 0 {
+		l.problems.add(Error, e.Name, -1, "size %v is negative", e.Size)
+		return
+	}
+	p := e.Position
+	transform := glm.Translate3Dd(p[0], p[1], p[2]).Mul4(template.Document)
+	child := gtk.NewModel(e.Name, []*gtk.Model{}, template.Geometry, glm.Ident4d())
+	body := NewBody(e.Name, shape, child, transform, template.Triangles)
+	body.Size = size
+	body.UpdateModel()
+	w.Bodies = append(w.Bodies, body)
+}
+
+func point(v [3]float64) glm.Vec4d {
+	return glm.Vec4d{v[0], v[1], v[2], 1}
+}
+
+func direction(v [3]float64) glm.Vec4d {
+	return glm.Vec4d{v[0], v[1], v[2], 0}
+}
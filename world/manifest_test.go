@@ -0,0 +1,88 @@
+package world
+
+import (
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"github.com/GlenKelley/portal/render"
+	glm "github.com/Jragonmiris/mathgl"
+	"strings"
+	"testing"
+)
+
+// geometries records the materials of the geometry a level is loaded with.
+type geometries map[string][]*render.Material
+
+func (g geometries) add(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *render.Material) *gtk.Geometry {
+	g[name] = append(g[name], material)
+	return &gtk.Geometry{}
+}
+
+func TestLoadManifest(t *testing.T) {
+	w := New(DefaultConstants)
+	_, problems, err := w.LoadManifest("testdata/manifest.json", geometries{}.add)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ellipse := w.Network.Horizons[1]
+	if _, ok := ellipse.Shape.(portal.Ellipse); !ok {
+		t.Errorf("portal 1 is a %T, want an ellipse", ellipse.Shape)
+	}
+	if !approxScale(ellipse.Scale, glm.Vec4d{1, 2, 1, 0}) {
+		t.Errorf("portal 1 scaled to %v, want half its size of (2, 4)", ellipse.Scale)
+	}
+	// left out, up is +Y, or -Z on the floor
+	if !ellipse.PlaneV.ApproxEqual(glm.Vec4d{1, 0, 0, 0}) {
+		t.Errorf("portal 1 without an up has PlaneV %v, want +x", ellipse.PlaneV)
+	}
+	if floor := w.Network.Horizons[7]; !floor.PlaneV.ApproxEqual(glm.Vec4d{1, 0, 0, 0}) {
+		t.Errorf("floor portal 7 without an up has PlaneV %v, want +x", floor.PlaneV)
+	}
+	if gun := w.Network.Horizons[2]; !approxScale(gun.Scale, glm.Vec4d{w.Constants.PortalWidth / 2, w.Constants.PortalHeight / 2, 1, 0}) {
+		t.Errorf("portal 2 without a size scaled to %v, want the gun's portal", gun.Scale)
+	}
+
+	// 4 is disabled, so 3 leading to it is left out too
+	for _, id := range []int{3, 4} {
+		if _, ok := w.Network.Horizons[id]; ok {
+			t.Errorf("portal %d is loaded", id)
+		}
+	}
+	expect(t, problems, 3, "exit 4 is disabled", Warning)
+	if ps := find(problems, 4, ""); len(ps) != 0 {
+		t.Errorf("disabled portal 4 has problems %v", ps)
+	}
+	// 5 and 6 both lead to portals which lead elsewhere, but only 6 is
+	// expected to lead back
+	if ps := find(problems, 5, "one way"); len(ps) != 0 {
+		t.Errorf("one way portal 5 has problems %v", ps)
+	}
+	expect(t, problems, 6, "one way link", Warning)
+	if w.Network.Links[5] != 1 || w.Network.Links[6] != 2 {
+		t.Errorf("links %v, want 5 to 1 and 6 to 2", w.Network.Links)
+	}
+
+	errors := problems.Errors()
+	if len(errors) != 1 || !strings.Contains(errors[0].Message, "gravity region 1") {
+		t.Errorf("errors %v, want only the inverted gravity region", errors)
+	}
+	if len(w.Gravity) != 1 || !w.Gravity[0].Bounds.Min.ApproxEqual(glm.Vec4d{5, 0, 5, 1}) {
+		t.Errorf("gravity regions %v, want only the first", w.Gravity)
+	}
+
+	// the crate's document is Z up in half meters, where it is 2 by 2 and 4 tall
+	if len(w.Bodies) != 1 {
+		t.Fatalf("%d bodies, want the crate", len(w.Bodies))
+	}
+	crate := w.Bodies[0]
+	if crate.Name != "crate" || crate.Shape != BoxBody || !crate.Position.ApproxEqual(glm.Vec4d{1, 2, -3, 1}) {
+		t.Errorf("%s (%v) at %v, want the crate box at (1, 2, -3)", crate.Name, crate.Shape, crate.Position)
+	}
+	if !crate.HalfExtents.ApproxEqual(glm.Vec4d{0.5, 1, 0.5, 0}) {
+		t.Errorf("crate half extents %v, want (0.5, 1, 0.5) meters upright", crate.HalfExtents)
+	}
+	if top := crate.Model.Transform.Mul4x1(glm.Vec4d{0, 0, 2, 1}); !top.ApproxEqual(glm.Vec4d{1, 3, -3, 1}) {
+		t.Errorf("the top of the crate is drawn at %v, want (1, 3, -3)", top)
+	}
+}
@@ -0,0 +1,133 @@
+package world
+
+import (
+	collada "github.com/GlenKelley/go-collada"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal/render"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readMaterials reads the materials of a COLLADA document from the common
+// profile of their effects, finding texture images relative to dir. Textures
+// whose image can't be found are left out and reported.
+func readMaterials(doc *collada.Collada, dir string) (map[collada.Id]*render.Material, Problems) {
+	problems := Problems{}
+	images := make(map[string]string)
+	for _, library := range doc.LibraryImages {
+		for _, image := range library.Image {
+			images[string(image.Id)] = image.InitFrom
+		}
+	}
+	effects := make(map[collada.Id]render.Material)
+	for _, library := range doc.LibraryEffects {
+		for _, effect := range library.Effect {
+			m := render.Material{string(effect.Id), render.DefaultDiffuse, ""}
+			if effect.ProfileCommon == nil {
+				effects[effect.Id] = m
+				continue
+			}
+			diffuse := commonDiffuse(effect.ProfileCommon.Technique)
+			if diffuse != nil && diffuse.Color != nil && len(diffuse.Color.Values) >= 3 {
+				c := diffuse.Color.Values
+				m.Diffuse = gtk.Color{gl.Float(c[0]), gl.Float(c[1]), gl.Float(c[2]), 1}
+				if len(c) >= 4 {
+					m.Diffuse[3] = gl.Float(c[3])
+				}
+			}
+			if diffuse != nil && diffuse.Texture != nil {
+				file, ok := images[textureImage(effect.ProfileCommon, diffuse.Texture.Texture)]
+				path := imagePath(dir, file)
+				if _, err := os.Stat(path); !ok || err != nil {
+					problems.add(Warning, string(effect.Id), -1, "diffuse texture %s not found", diffuse.Texture.Texture)
+				} else {
+					m.Texture = path
+					m.Diffuse = gtk.Color{1, 1, 1, 1}
+				}
+			}
+			effects[effect.Id] = m
+		}
+	}
+	materials := make(map[collada.Id]*render.Material)
+	for _, library := range doc.LibraryMaterials {
+		for _, material := range library.Material {
+			m := &render.Material{string(material.Id), render.DefaultDiffuse, ""}
+			if material.InstanceEffect != nil {
+				id, _ := material.InstanceEffect.Url.Id()
+				if effect, ok := effects[id]; ok {
+					m.Diffuse, m.Texture = effect.Diffuse, effect.Texture
+				} else {
+					problems.add(Warning, string(material.Id), -1, "no effect %s", material.InstanceEffect.Url)
+				}
+			}
+			materials[material.Id] = m
+		}
+	}
+	return materials, problems
+}
+
+// imagePath finds an image's init_from, which may be a file uri, relative to
+// the document's directory.
+func imagePath(dir, file string) string {
+	path := filepath.FromSlash(strings.TrimPrefix(file, "file://"))
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func commonDiffuse(t *collada.TechniqueFxCommon) *collada.CommonColorOrTextureType {
+	switch {
+	case t == nil:
+		return nil
+	case t.Lambert != nil:
+		return t.Lambert.Diffuse
+	case t.Phong != nil:
+		return t.Phong.Diffuse
+	case t.Blinn != nil:
+		return t.Blinn.Diffuse
+	}
+	return nil
+}
+
+// textureImage follows a texture's sampler and surface parameters to the id
+// of its image. Some exporters name the image directly instead.
+func textureImage(profile *collada.ProfileCommon, texture string) string {
+	params := make(map[string]*collada.Newparam)
+	for _, p := range profile.Newparam {
+		params[p.Sid] = p
+	}
+	sampler, ok := params[texture]
+	if !ok || sampler.Sampler2D == nil {
+		return texture
+	}
+	surface, ok := params[sampler.Sampler2D.Source]
+	if !ok || surface.Surface == nil {
+		return sampler.Sampler2D.Source
+	}
+	return surface.Surface.InitFrom
+}
+
+// materialBindings maps the material symbols used by each mesh to the
+// materials its instances bind them to.
+func materialBindings(nodes []*collada.Node) map[collada.Id]map[string]collada.Id {
+	bindings := make(map[collada.Id]map[string]collada.Id)
+	walkNodes(nodes, func(node *collada.Node) {
+		for _, geoinstance := range node.InstanceGeometry {
+			if geoinstance.BindMaterial == nil || geoinstance.BindMaterial.TechniqueCommon == nil {
+				continue
+			}
+			geoid, _ := geoinstance.Url.Id()
+			if bindings[geoid] == nil {
+				bindings[geoid] = make(map[string]collada.Id)
+			}
+			for _, im := range geoinstance.BindMaterial.TechniqueCommon.InstanceMaterial {
+				target, _ := im.Target.Id()
+				bindings[geoid][im.Symbol] = target
+			}
+		}
+	})
+	return bindings
+}
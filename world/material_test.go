@@ -0,0 +1,42 @@
+package world
+
+import (
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal/render"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMaterials(t *testing.T) {
+	w := New(DefaultConstants)
+	g := geometries{}
+	_, problems, err := w.LoadScene("testdata/materials.dae", g.add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one geometry for each polylist, in order
+	want := []render.Material{
+		// Bricks is bound to Brick-material, a phong textured through its
+		// sampler and surface
+		{"Brick-material", gtk.Color{1, 1, 1, 1}, filepath.Join("testdata", "brick.png")},
+		// Red-material isn't bound, and is the material of that id
+		{"Red-material", gtk.Color{0.8, 0.1, 0.1, 1}, ""},
+		// missing.png isn't there, so the texture is left out
+		{"Missing-material", render.DefaultDiffuse, ""},
+	}
+	materials := g["Wall-mesh"]
+	if len(materials) != len(want) {
+		t.Fatalf("%d geometries, want %d", len(materials), len(want))
+	}
+	for i, m := range materials {
+		if m == nil || *m != want[i] {
+			t.Errorf("polylist %d has material %v, want %v", i, m, want[i])
+		}
+	}
+	if len(problems) != 1 {
+		t.Fatalf("problems %v, want the missing texture", problems)
+	}
+	if p := problems[0]; p.Severity != Warning || p.Node != "Missing-effect" || p.Message != "diffuse texture missing_png-sampler not found" {
+		t.Errorf("problem %v, want the missing texture as a warning", p)
+	}
+}
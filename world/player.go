@@ -0,0 +1,94 @@
+package world
+
+import (
+	"fmt"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+)
+
+type Player struct {
+	Position     glm.Vec4d
+	Velocity     glm.Vec4d
+	PanAxis      glm.Vec4d // up, in world space
+	TiltAxis     glm.Vec4d // right, in the player's own frame
+	OrientationH glm.Quatd
+	Orientation  glm.Quatd
+	Size         float64 // scales the collision volume and walking speed
+	Righting     float64 // seconds left to turn upright
+}
+
+// NewPlayer stands a player at position, upright along Y.
+func NewPlayer(position glm.Vec4d) Player {
+	return Player{
+		position,
+		glm.Vec4d{0, 0, 0, 0},
+		glm.Vec4d{0, 1, 0, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.QuatIdentd(),
+		glm.QuatIdentd(),
+		1,
+		0,
+	}
+}
+
+// Spawn is where the player starts and the way they face.
+type Spawn struct {
+	Position glm.Vec4d
+	Facing   glm.Vec4d
+}
+
+var DefaultSpawn = Spawn{glm.Vec4d{0, 1, 0, 1}, glm.Vec4d{0, 0, -1, 0}}
+
+// SpawnPlayer stands a new player at the spawn, upright against the gravity
+// there and facing as near to the spawn's facing as that allows.
+func (w *World) SpawnPlayer() {
+	p := NewPlayer(w.Spawn.Position)
+	up := w.UpAt(p.Position)
+	p.PanAxis = up
+	look, err := portal.ShortestArc(glm.Vec4d{0, 0, -1, 0}, w.Spawn.Facing, glm.Vec3d{0, 1, 0})
+	if err != nil {
+		w.report(fmt.Errorf("spawn facing: %v", err))
+	}
+	p.Orientation = Upright(look, up)
+	p.TiltAxis = TiltAxis(p.Orientation, up, p.TiltAxis)
+	if h, ok := Heading(p.Orientation, up); ok {
+		p.OrientationH = h
+	}
+	w.Player = p
+}
+
+// Cross carries the player through a portal, turning them with it and
+// scaling them if it scales with the portal.
+func (p *Player) Cross(g portal.Passage) {
+	p.Position = g.Point(p.Position)
+	p.Velocity = g.Vector(p.Velocity)
+	p.Orientation = g.Rotation.Mul(p.Orientation)
+	p.OrientationH = g.Rotation.Mul(p.OrientationH)
+	p.Size *= g.Size()
+}
+
+// Capsule is the player's collision volume, from their feet up to their eyes.
+func (p *Player) Capsule(c Constants) portal.Capsule {
+	feet := p.Position.Sub(p.PanAxis.Mul((c.PlayerHeight - c.PlayerRadius) * p.Size))
+	return portal.Capsule{feet, p.Position, c.PlayerRadius * p.Size}
+}
+
+// groundSkin is how far below a player of size 1 a surface still holds them
+// up.
+const groundSkin = 0.01
+
+// Grounded reports whether any of the surfaces touched holds the player up.
+func (p *Player) Grounded(normals []glm.Vec4d) bool {
+	for _, n := range normals {
+		if n.Dot(p.PanAxis) > 0.7 {
+			return true
+		}
+	}
+	return false
+}
+
+// UIState is the movement the player's input currently asks for.
+type UIState struct {
+	Impulse  glm.Vec4d
+	Movement glm.Vec4d
+}
@@ -0,0 +1,495 @@
+package world
+
+import (
+	"fmt"
+	collada "github.com/GlenKelley/go-collada"
+	gl "github.com/GlenKelley/go-gl/gl32"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	"github.com/GlenKelley/portal/render"
+	glm "github.com/Jragonmiris/mathgl"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// GeometryFunc makes the drawable geometry for a mesh, as render.Renderer's
+// NewSurface does.
+type GeometryFunc func(name string, vertices, normals, texcoords []float64, elements map[gl.Enum][]int16, material *render.Material) *gtk.Geometry
+
+var UnitQuad = portal.Quad{
+	glm.Vec4d{0, 0, 0, 1},
+	glm.Vec4d{0, 0, 1, 0},
+	glm.Vec4d{1, 0, 0, 0},
+	glm.Vec4d{1, 1, 1, 0},
+	portal.Rectangle{},
+}
+
+// Load reads a level, either a COLLADA document or a .json manifest, adds the
+// floor, and sets up the gun portals and the player. It returns the model to
+// draw and the problems found in the level.
+func (w *World) Load(filename string, newGeometry GeometryFunc) (*gtk.Model, Problems, error) {
+	load := w.LoadScene
+	if filepath.Ext(filename) == ".json" {
+		load = w.LoadManifest
+	}
+	root := gtk.EmptyModel("root")
+	model, problems, err := load(filename, newGeometry)
+	if err != nil {
+		return nil, nil, err
+	}
+	root.AddChild(model)
+
+	floor := portal.Quad{
+		glm.Vec4d{0, 0, 0, 1},
+		glm.Vec4d{0, 1, 0, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{10, 10, 1, 0},
+		portal.Rectangle{},
+	}
+	vs, ns := floor.Mesh()
+	root.AddGeometry(newGeometry("plane1", vs, ns, nil, floor.Elements(), nil))
+	w.Level.AddGroup("plane1", floor.Triangles("plane1"))
+	w.Level.Build()
+
+	next := w.Network.NextId()
+	w.GunPortals = [2]int{next, next + 1}
+	w.Network.Link(next, next+1)
+	w.RebuildPortals()
+	w.SpawnPlayer()
+	return root, problems, nil
+}
+
+// LoadScene reads the models and portals of a COLLADA document. Nodes named
+// Portal_N_M are portal N leading to portal M, and a _scale or _preserve
+// suffix sets how the portal carries motion. Nodes named Cube_* and Sphere_*
+// are loaded as bodies, and a node named Spawn places the player. The
+// document's up axis and unit are converted to the world's. Nodes may be
+// nested, or instanced from anywhere in the document with instance_node, and
+// their transforms accumulate. Portals that cannot be placed are left out and
+// reported with the network's other problems.
+func (w *World) LoadScene(filename string, newGeometry GeometryFunc) (*gtk.Model, Problems, error) {
+	l := w.newLevelLoader(newGeometry)
+	err := l.importScene(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	model, problems := l.finish()
+	return model, problems, nil
+}
+
+var (
+	portalPattern = regexp.MustCompile("^Portal_(\\d+)_(\\d+)(?:_(scale|preserve))?")
+	bodyPattern   = regexp.MustCompile("^(Cube|Sphere)_")
+	spawnPattern  = regexp.MustCompile("^Spawn")
+)
+
+// meshTemplate is a mesh ready to be instanced, along with the transform of
+// the document it came from.
+type meshTemplate struct {
+	Geometry  []*gtk.Geometry
+	Triangles []portal.Triangle
+	Document  glm.Mat4d
+}
+
+// levelLoader gathers a level from one or more documents into the world.
+type levelLoader struct {
+	world       *World
+	newGeometry GeometryFunc
+	model       *gtk.Model
+	meshes      map[string]meshTemplate
+	names       map[int]string // where each portal was declared
+	oneWay      map[int]bool   // portals not expected to be led back to
+	used        map[string]bool
+	spawn       string // the node the spawn came from
+	problems    Problems
+}
+
+// newLevelLoader clears the world's level.
+func (w *World) newLevelLoader(newGeometry GeometryFunc) *levelLoader {
+	w.Level = portal.Scene{}
+	w.Network = portal.NewNetwork()
+	w.Colliders = make(map[string]Collider)
+	w.Bodies = []*Body{}
+	w.Gravity = nil
+	w.Spawn = DefaultSpawn
+	return &levelLoader{
+		w,
+		newGeometry,
+		gtk.EmptyModel("scene"),
+		make(map[string]meshTemplate),
+		make(map[int]string),
+		make(map[int]bool),
+		make(map[string]bool),
+		"",
+		Problems{},
+	}
+}
+
+// documentTransform converts a COLLADA document's coordinates into the
+// world's, which are Y up in meters with -Z forward. mathgl's rotations take
+// degrees.
+func (l *levelLoader) documentTransform(filename string, asset *collada.Asset) glm.Mat4d {
+	m := glm.Ident4d()
+	if asset == nil {
+		return m
+	}
+	switch asset.UpAxis {
+	case collada.Xup:
+		// right is -Y, up is X and in is Z
+		m = glm.HomogRotate3DZd(90)
+	case collada.Zup:
+		// right is X, up is Z and in is -Y
+		m = glm.HomogRotate3DXd(-90)
+	}
+	if asset.Unit != nil {
+		meter := asset.Unit.Meter
+		if meter > 0 {
+			m = m.Mul4(glm.Scale3Dd(meter, meter, meter))
+		} else {
+			l.problems.add(Warning, filename, -1, "unit of %v meters is ignored", meter)
+		}
+	}
+	return m
+}
+
+// importScene adds the meshes, models and portals of a COLLADA document.
+func (l *levelLoader) importScene(filename string) error {
+	doc, err := collada.LoadDocument(filename)
+	if err != nil {
+		return err
+	}
+	index, err := gtk.NewIndex(doc)
+	if err != nil {
+		return err
+	}
+
+	model := gtk.EmptyModel(filename)
+	model.Transform = l.documentTransform(filename, doc.Asset)
+	l.model.AddChild(model)
+
+	// A portal node's mesh only marks where the portal goes; it is drawn as
+	// the portal, so it is left out of the level unless another node uses it.
+	roots := index.VisualScene.Node
+	for _, library := range doc.LibraryNodes {
+		roots = append(roots, library.Node...)
+	}
+	nodes := make(map[collada.Id]*collada.Node)
+	instanced := make(map[collada.Id]map[bool]bool)
+	walkNodes(roots, func(node *collada.Node) {
+		if node.Id != "" {
+			nodes[node.Id] = node
+		}
+		for _, geoinstance := range node.InstanceGeometry {
+			geoid, _ := geoinstance.Url.Id()
+			if instanced[geoid] == nil {
+				instanced[geoid] = make(map[bool]bool)
+			}
+			instanced[geoid][portalPattern.MatchString(node.Name)] = true
+		}
+	})
+
+	materials, problems := readMaterials(doc, filepath.Dir(filename))
+	l.problems = append(l.problems, problems...)
+	bindings := materialBindings(roots)
+	for id, mesh := range index.Mesh {
+		if instanced[id][true] && !instanced[id][false] {
+			continue
+		}
+		if portalPattern.MatchString(mesh.VerticesId) {
+			l.problems.add(Warning, string(id), -1, "mesh is named like a portal but is drawn as level geometry")
+		}
+		if _, ok := l.meshes[string(id)]; ok {
+			l.problems.add(Warning, string(id), -1, "mesh id is used by an earlier document, which it replaces")
+		}
+		template := meshTemplate{[]*gtk.Geometry{}, []portal.Triangle{}, model.Transform}
+		for _, pl := range mesh.Polylist {
+			template.Triangles = append(template.Triangles, portal.Triangles(string(id), pl.VertexData, pl.TriangleElements, glm.Ident4d())...)
+			elements := map[gl.Enum][]int16{}
+			if len(pl.TriangleElements) > 0 {
+				elements[gl.TRIANGLES] = pl.TriangleElements
+			}
+			texcoords := pl.TexcoordData
+			if len(texcoords) > 0 && len(texcoords)/2 != len(pl.VertexData)/3 {
+				l.problems.add(Warning, string(id), -1, "%d texture coordinates for %d vertices are ignored", len(texcoords)/2, len(pl.VertexData)/3)
+				texcoords = nil
+			}
+			material := l.material(materials, bindings[id], pl.Material, string(id))
+			template.Geometry = append(template.Geometry, l.newGeometry(string(id), pl.VertexData, pl.NormalData, texcoords, elements, material))
+		}
+		if len(template.Geometry) > 0 {
+			l.meshes[string(id)] = template
+		}
+	}
+
+	d := &sceneDocument{nodes, map[collada.Id]bool{}}
+	for _, node := range index.VisualScene.Node {
+		l.importNode(d, node, model, model.Transform)
+	}
+	return nil
+}
+
+// material finds the material a polylist's symbol is bound to, or the
+// material of that id when no instance binds it. No symbol is no material.
+func (l *levelLoader) material(materials map[collada.Id]*render.Material, bindings map[string]collada.Id, symbol, mesh string) *render.Material {
+	if symbol == "" {
+		return nil
+	}
+	target, ok := bindings[symbol]
+	if !ok {
+		target = collada.Id(symbol)
+	}
+	m, ok := materials[target]
+	if !ok {
+		l.problems.add(Warning, mesh, -1, "no material %s", target)
+	}
+	return m
+}
+
+// sceneDocument is the part of a COLLADA document nodes are imported from.
+type sceneDocument struct {
+	nodes     map[collada.Id]*collada.Node // every node with an id, for instance_node
+	instances map[collada.Id]bool          // the nodes being instanced, to stop cycles
+}
+
+// walkNodes visits nodes and their descendants, parents first.
+func walkNodes(nodes []*collada.Node, visit func(*collada.Node)) {
+	for _, node := range nodes {
+		visit(node)
+		walkNodes(node.Node, visit)
+	}
+}
+
+// nodeTransform composes a node's matrix, translate, rotate and scale
+// elements, in that order, into its transform relative to its parent. COLLADA
+// matrices are row major and rotations are in degrees, as QuatRotated takes
+// them. Elements with the wrong number of values are ignored.
+func (l *levelLoader) nodeTransform(node *collada.Node) glm.Mat4d {
+	m := glm.Ident4d()
+	malformed := func(element string, v []float64) {
+		l.problems.add(Warning, node.Name, -1, "%s of %d values is ignored", element, len(v))
+	}
+	for _, matrix := range node.Matrix {
+		v := matrix.F()
+		if len(v) != 16 {
+			malformed("matrix", v)
+			continue
+		}
+		m = m.Mul4(glm.Mat4d{
+			v[0], v[4], v[8], v[12],
+			v[1], v[5], v[9], v[13],
+			v[2], v[6], v[10], v[14],
+			v[3], v[7], v[11], v[15],
+		})
+	}
+	for _, translate := range node.Translate {
+		v := translate.F()
+		if len(v) != 3 {
+			malformed("translate", v)
+			continue
+		}
+		m = m.Mul4(glm.Translate3Dd(v[0], v[1], v[2]))
+	}
+	for _, rotate := range node.Rotate {
+		v := rotate.F()
+		if len(v) != 4 {
+			malformed("rotate", v)
+			continue
+		}
+		axis := glm.Vec3d{v[0], v[1], v[2]}
+		if axis.Len() == 0 {
+			l.problems.add(Warning, node.Name, -1, "rotate about no axis is ignored")
+			continue
+		}
+		m = m.Mul4(glm.QuatRotated(v[3], axis.Normalize()).Mat4())
+	}
+	for _, scale := range node.Scale {
+		v := scale.F()
+		if len(v) != 3 {
+			malformed("scale", v)
+			continue
+		}
+		m = m.Mul4(glm.Scale3Dd(v[0], v[1], v[2]))
+	}
+	return m
+}
+
+// importNode adds a node and its descendants below parent, whose transform
+// into world space is parentWorld. Level geometry gets a model mirroring the
+// node tree, while portals and bodies are placed in world space.
+func (l *levelLoader) importNode(d *sceneDocument, node *collada.Node, parent *gtk.Model, parentWorld glm.Mat4d) {
+	w := l.world
+	transform := l.nodeTransform(node)
+	mt := parentWorld.Mul4(transform)
+	name := l.uniqueName(node.Name)
+
+	geoms := make([]*gtk.Geometry, 0)
+	triangles := make([]portal.Triangle, 0)
+	for _, geoinstance := range node.InstanceGeometry {
+		geoid, _ := geoinstance.Url.Id()
+		geoms = append(geoms, l.meshes[string(geoid)].Geometry...)
+		triangles = append(triangles, l.meshes[string(geoid)].Triangles...)
+	}
+
+	if body := bodyPattern.FindStringSubmatch(node.Name); body != nil {
+		shape := BoxBody
+		if body[1] == "Sphere" {
+			shape = SphereBody
+		}
+		if len(node.Node) > 0 || len(node.InstanceNode) > 0 {
+			l.problems.add(Warning, node.Name, -1, "the children of a body are ignored")
+		}
+		child := gtk.NewModel(name, []*gtk.Model{}, geoms, glm.Ident4d())
+		w.Bodies = append(w.Bodies, NewBody(name, shape, child, mt, triangles))
+		return
+	}
+
+	if spawnPattern.MatchString(node.Name) {
+		l.importSpawn(node.Name, mt)
+	}
+	if matches := portalPattern.FindStringSubmatch(node.Name); matches != nil {
+		l.importPortal(node.Name, matches, mt)
+		geoms = []*gtk.Geometry{}
+	}
+	child := gtk.NewModel(name, []*gtk.Model{}, geoms, transform)
+	parent.AddChild(child)
+	if len(geoms) > 0 {
+		collider := Collider{child, parentWorld, triangles, 0}
+		collider.Group = w.Level.AddGroup(name, collider.World())
+		w.Colliders[name] = collider
+	}
+
+	for _, c := range node.Node {
+		l.importNode(d, c, child, mt)
+	}
+	for _, instance := range node.InstanceNode {
+		id, _ := instance.Url.Id()
+		target, ok := d.nodes[id]
+		if !ok {
+			l.problems.add(Error, node.Name, -1, "instance of unknown node %s", instance.Url)
+			continue
+		}
+		if d.instances[id] {
+			l.problems.add(Error, node.Name, -1, "node %s instances itself", id)
+			continue
+		}
+		d.instances[id] = true
+		l.importNode(d, target, child, mt)
+		delete(d.instances, id)
+	}
+}
+
+// importPortal places the unit quad by a portal node's world transform.
+func (l *levelLoader) importPortal(name string, matches []string, mt glm.Mat4d) {
+	index, err := strconv.Atoi(matches[1])
+	if err != nil {
+		l.problems.add(Error, name, -1, "bad portal index: %v", err)
+		return
+	}
+	exit, err := strconv.Atoi(matches[2])
+	if err != nil {
+		l.problems.add(Error, name, index, "bad exit index: %v", err)
+		return
+	}
+	invalid := ValidateTransform(name, index, mt)
+	l.problems = append(l.problems, invalid...)
+	if len(invalid.Errors()) > 0 {
+		return
+	}
+	transit, _ := portal.ParseTransit(matches[3])
+	l.addPortal(name, index, UnitQuad.Apply(mt), &exit, transit)
+}
+
+// importSpawn starts the player at a node's origin, facing along its -Z
+// axis as a COLLADA camera does.
+func (l *levelLoader) importSpawn(name string, mt glm.Mat4d) {
+	if l.spawn != "" {
+		l.problems.add(Warning, name, -1, "replaces the spawn at %s", l.spawn)
+	}
+	l.spawn = name
+	l.world.Spawn = Spawn{
+		mt.Mul4x1(glm.Vec4d{0, 0, 0, 1}),
+		mt.Mul4x1(glm.Vec4d{0, 0, -1, 0}).Normalize(),
+	}
+}
+
+// uniqueName is name, numbered when an instanced node has already used it.
+func (l *levelLoader) uniqueName(name string) string {
+	unique := name
+	for i := 2; l.used[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	l.used[unique] = true
+	return unique
+}
+
+// addPortal puts a horizon into the network, unless its id is taken. A nil
+// exit leaves the horizon as an exit only.
+func (l *levelLoader) addPortal(name string, id int, horizon portal.Quad, exit *int, transit portal.Transit) {
+	if first, ok := l.names[id]; ok {
+		l.problems.add(Error, name, id, "duplicate index, already used by %s", first)
+		return
+	}
+	n := &l.world.Network
+	l.names[id] = name
+	n.Horizons[id] = horizon
+	n.Transits[id] = transit
+	if exit != nil {
+		n.Links[id] = *exit
+	}
+}
+
+// finish checks the portal network and returns the level's model. Portals
+// linked to themselves are left as exits only; Network.Portals already leaves
+// out those whose exit is missing.
+func (l *levelLoader) finish() (*gtk.Model, Problems) {
+	n := &l.world.Network
+	l.problems = append(l.problems, ValidateNetwork(*n, l.names, l.oneWay)...)
+	for id, exit := range n.Links {
+		if exit == id {
+			delete(n.Links, id)
+		}
+	}
+	return l.model, l.problems
+}
+
+// Collider keeps a child model's triangles in model space, so they can be
+// placed again when the model moves.
+type Collider struct {
+	Model     *gtk.Model
+	Parent    glm.Mat4d
+	Triangles []portal.Triangle
+	Group     int // of the level's scene
+}
+
+func (c *Collider) World() []portal.Triangle {
+	mt := c.Parent.Mul4(c.Model.Transform)
+	world := make([]portal.Triangle, len(c.Triangles))
+	for i, t := range c.Triangles {
+		world[i] = t.Apply(mt)
+	}
+	return world
+}
+
+// CreatePortals is a fixed pair of test portals.
+func CreatePortals() []portal.Portal {
+	a := portal.Quad{
+		glm.Vec4d{0, 1, -5, 1},
+		glm.Vec4d{0, 0, 1, 0},
+		glm.Vec4d{1, 0, 0, 0},
+		glm.Vec4d{1, 1, 1, 0},
+		portal.Rectangle{},
+	}
+	r := glm.HomogRotate3DYd(90)
+	b := portal.Quad{
+		glm.Vec4d{-2, 1, 0, 1},
+		r.Mul4x1(glm.Vec4d{0, 0, 1, 0}),
+		r.Mul4x1(glm.Vec4d{1, 0, 0, 0}),
+		glm.Vec4d{1, 1, 1, 0},
+		portal.Rectangle{},
+	}
+	pair := portal.PortalTransform(a, b)
+	pa := portal.Portal{a, pair.BA, pair.ALocal, portal.PreserveSpeed}
+	pb := portal.Portal{b, pair.AB, pair.BLocal, portal.PreserveSpeed}
+	return []portal.Portal{pa, pb}
+}
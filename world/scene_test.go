@@ -0,0 +1,283 @@
+package world
+
+import (
+	collada "github.com/GlenKelley/go-collada"
+	glm "github.com/Jragonmiris/mathgl"
+	"strings"
+	"testing"
+)
+
+func translate(x, y, z string) *collada.Translate {
+	return &collada.Translate{Float3: collada.Float3{collada.Values{V: x + " " + y + " " + z}}}
+}
+
+func rotate(axisAngle string) *collada.Rotate {
+	return &collada.Rotate{Float4: collada.Float4{collada.Values{V: axisAngle}}}
+}
+
+func scale(xyz string) *collada.Scale {
+	return &collada.Scale{Float3: collada.Float3{collada.Values{V: xyz}}}
+}
+
+func instance(id string) *collada.InstanceNode {
+	return &collada.InstanceNode{Url: collada.Uri("#" + id)}
+}
+
+// importNodes imports a visual scene's nodes as importScene does, with the
+// library's nodes available to instance.
+func importNodes(scene, library []*collada.Node) (*World, Problems) {
+	return importDocument(nil, scene, library)
+}
+
+// importDocument is importNodes in the units and up axis of asset.
+func importDocument(asset *collada.Asset, scene, library []*collada.Node) (*World, Problems) {
+	w := New(DefaultConstants)
+	l := w.newLevelLoader(nil)
+	document := l.documentTransform("document", asset)
+	nodes := make(map[collada.Id]*collada.Node)
+	walkNodes(append(append([]*collada.Node{}, scene...), library...), func(node *collada.Node) {
+		if node.Id != "" {
+			nodes[node.Id] = node
+		}
+	})
+	d := &sceneDocument{nodes, map[collada.Id]bool{}}
+	for _, node := range scene {
+		l.importNode(d, node, l.model, document)
+	}
+	return w, l.problems
+}
+
+func TestNodeTransform(t *testing.T) {
+	l := New(DefaultConstants).newLevelLoader(nil)
+	node := &collada.Node{
+		Name:      "node",
+		Matrix:    []*collada.Matrix{{Float4x4: collada.Float4x4{collada.Values{V: "1 0 0 1  0 1 0 2  0 0 1 3  0 0 0 1"}}}},
+		Translate: []*collada.Translate{translate("0", "0", "-5")},
+		Rotate:    []*collada.Rotate{rotate("0 1 0 90")},
+		Scale:     []*collada.Scale{scale("2 2 2")},
+	}
+	m := l.nodeTransform(node)
+	if p := m.Mul4x1(glm.Vec4d{0, 0, 0, 1}); !p.ApproxEqual(glm.Vec4d{1, 2, -2, 1}) {
+		t.Errorf("origin placed at %v, want the matrix then translate", p)
+	}
+	if x := m.Mul4x1(glm.Vec4d{1, 0, 0, 0}); !x.ApproxEqual(glm.Vec4d{0, 0, -2, 0}) {
+		t.Errorf("x axis is %v, want scaled then rotated about y", x)
+	}
+	if len(l.problems) != 0 {
+		t.Errorf("problems %v", l.problems)
+	}
+
+	bad := &collada.Node{Name: "bad", Translate: []*collada.Translate{{Float3: collada.Float3{collada.Values{V: "1 2"}}}}, Rotate: []*collada.Rotate{rotate("0 0 0 90")}}
+	if m := l.nodeTransform(bad); !m.ApproxEqual(glm.Ident4d()) {
+		t.Errorf("malformed elements give %v", m)
+	}
+	if len(l.problems) != 2 {
+		t.Errorf("want warnings for the short translate and the axisless rotate, got %v", l.problems)
+	}
+}
+
+func TestImportNestedNodes(t *testing.T) {
+	// neither the parent nor the portal has an id
+	scene := []*collada.Node{{
+		Name:      "Room",
+		Translate: []*collada.Translate{translate("10", "0", "0")},
+		Rotate:    []*collada.Rotate{rotate("0 1 0 90")},
+		Node: []*collada.Node{{
+			Name:      "Portal_1_2",
+			Translate: []*collada.Translate{translate("0", "1", "-5")},
+		}, {
+			Name:      "Spawn",
+			Translate: []*collada.Translate{translate("0", "0", "3")},
+		}},
+	}}
+	w, problems := importNodes(scene, nil)
+	if len(problems) != 0 {
+		t.Errorf("problems %v", problems)
+	}
+	horizon, ok := w.Network.Horizons[1]
+	if !ok {
+		t.Fatal("the nested portal was not loaded")
+	}
+	if !horizon.Center.ApproxEqual(glm.Vec4d{5, 1, 0, 1}) {
+		t.Errorf("nested portal at %v, want its transform after its parent's", horizon.Center)
+	}
+	if !w.Spawn.Position.ApproxEqual(glm.Vec4d{13, 0, 0, 1}) || !w.Spawn.Facing.ApproxEqual(glm.Vec4d{-1, 0, 0, 0}) {
+		t.Errorf("spawn %v, want at (13, 0, 0) facing -x", w.Spawn)
+	}
+}
+
+func TestImportInstanceNode(t *testing.T) {
+	library := []*collada.Node{{
+		Id:        "door",
+		Name:      "door",
+		Translate: []*collada.Translate{translate("0", "0", "-1")},
+		Node: []*collada.Node{{
+			Name:      "Portal_3_4",
+			Translate: []*collada.Translate{translate("0", "2", "0")},
+		}},
+	}}
+	scene := []*collada.Node{{
+		Name:         "Hall",
+		Translate:    []*collada.Translate{translate("0", "0", "-10")},
+		InstanceNode: []*collada.InstanceNode{instance("door")},
+	}, {
+		Name:         "Broken",
+		InstanceNode: []*collada.InstanceNode{instance("nowhere")},
+	}}
+	w, problems := importNodes(scene, library)
+	horizon, ok := w.Network.Horizons[3]
+	if !ok {
+		t.Fatal("the instanced portal was not loaded")
+	}
+	if !horizon.Center.ApproxEqual(glm.Vec4d{0, 2, -11, 1}) {
+		t.Errorf("instanced portal at %v, want the library node's transform under the instancing node's", horizon.Center)
+	}
+	if len(w.Network.Horizons) != 1 {
+		t.Errorf("library nodes are imported without being instanced: %v", w.Network.Horizons)
+	}
+	if len(problems) != 1 || problems[0].Severity != Error || !strings.Contains(problems[0].Message, "unknown node") {
+		t.Errorf("problems %v, want one for the unknown node", problems)
+	}
+}
+
+func TestImportInstanceCycle(t *testing.T) {
+	library := []*collada.Node{{
+		Id:           "a",
+		Name:         "a",
+		Translate:    []*collada.Translate{translate("1", "0", "0")},
+		InstanceNode: []*collada.InstanceNode{instance("b")},
+	}, {
+		Id:           "b",
+		Name:         "b",
+		InstanceNode: []*collada.InstanceNode{instance("a")},
+	}}
+	scene := []*collada.Node{{Name: "Root", InstanceNode: []*collada.InstanceNode{instance("a"), instance("a")}}}
+	_, problems := importNodes(scene, library)
+	if len(problems) != 2 {
+		t.Fatalf("problems %v, want the cycle reported for each instance", problems)
+	}
+	for _, p := range problems {
+		if p.Severity != Error || !strings.Contains(p.Message, "instances itself") {
+			t.Errorf("problem %v, want the cycle as an error", p)
+		}
+	}
+}
+
+func TestDocumentTransform(t *testing.T) {
+	// the world point (1, 2, 3) as each up axis writes it
+	axes := []struct {
+		up    collada.UpAxis
+		point glm.Vec4d
+	}{
+		{collada.Xup, glm.Vec4d{2, -1, 3, 1}},
+		{collada.Yup, glm.Vec4d{1, 2, 3, 1}},
+		{collada.Zup, glm.Vec4d{1, -3, 2, 1}},
+	}
+	l := New(DefaultConstants).newLevelLoader(nil)
+	for _, axis := range axes {
+		m := l.documentTransform("document", &collada.Asset{UpAxis: axis.up})
+		if p := m.Mul4x1(axis.point); !p.ApproxEqual(glm.Vec4d{1, 2, 3, 1}) {
+			t.Errorf("%s: %v is converted to %v, want (1, 2, 3)", axis.up, axis.point, p)
+		}
+		if d := m.Det(); d < 1-1e-9 || d > 1+1e-9 {
+			t.Errorf("%s: conversion has determinant %v, want a rotation", axis.up, d)
+		}
+	}
+	if m := l.documentTransform("document", nil); !m.ApproxEqual(glm.Ident4d()) {
+		t.Errorf("a document without an asset is converted by %v", m)
+	}
+	if len(l.problems) != 0 {
+		t.Errorf("problems %v", l.problems)
+	}
+}
+
+func TestDocumentUnit(t *testing.T) {
+	l := New(DefaultConstants).newLevelLoader(nil)
+	cm := l.documentTransform("document", &collada.Asset{UpAxis: collada.Zup, Unit: &collada.Unit{"centimeter", 0.01}})
+	if p := cm.Mul4x1(glm.Vec4d{100, -300, 200, 1}); !p.ApproxEqual(glm.Vec4d{1, 2, 3, 1}) {
+		t.Errorf("centimeters converted to %v, want (1, 2, 3) meters", p)
+	}
+	if len(l.problems) != 0 {
+		t.Errorf("problems %v", l.problems)
+	}
+	for _, meter := range []float64{0, -1} {
+		l.problems = Problems{}
+		m := l.documentTransform("document", &collada.Asset{UpAxis: collada.Yup, Unit: &collada.Unit{"broken", meter}})
+		if !m.ApproxEqual(glm.Ident4d()) {
+			t.Errorf("a unit of %v meters scales by %v", meter, m)
+		}
+		if len(l.problems) != 1 || l.problems[0].Severity != Warning {
+			t.Errorf("a unit of %v meters gives problems %v, want a warning", meter, l.problems)
+		}
+	}
+}
+
+func TestImportUpAxes(t *testing.T) {
+	// the same portal and spawn, authored in each up axis and unit: a unit
+	// portal at (1, 2, 3) facing +x, and a spawn at (0, 1, 4) facing -z. Nodes
+	// face along their own axes in the document's convention, so a spawn in
+	// a Z up document is turned to look forward rather than down.
+	scenes := []struct {
+		asset  *collada.Asset
+		portal []*collada.Translate
+		facing *collada.Rotate
+		scale  *collada.Scale
+		spawn  *collada.Translate
+		look   *collada.Rotate
+	}{
+		{
+			&collada.Asset{UpAxis: collada.Yup},
+			[]*collada.Translate{translate("1", "2", "3")},
+			rotate("0 1 0 90"),
+			scale("1 1 1"),
+			translate("0", "1", "4"),
+			rotate("1 0 0 0"),
+		},
+		{
+			&collada.Asset{UpAxis: collada.Zup, Unit: &collada.Unit{"centimeter", 0.01}},
+			[]*collada.Translate{translate("100", "-300", "200")},
+			rotate("0 1 0 90"),
+			scale("100 100 100"),
+			translate("0", "-400", "100"),
+			rotate("1 0 0 90"),
+		},
+		{
+			&collada.Asset{UpAxis: collada.Xup},
+			[]*collada.Translate{translate("1", "0", "0"), translate("1", "-1", "3")},
+			rotate("1 0 0 90"),
+			scale("1 1 1"),
+			translate("1", "0", "4"),
+			rotate("1 0 0 0"),
+		},
+	}
+	for _, s := range scenes {
+		scene := []*collada.Node{{
+			Name:      "Portal_1_2",
+			Translate: s.portal,
+			Rotate:    []*collada.Rotate{s.facing},
+			Scale:     []*collada.Scale{s.scale},
+		}, {
+			Name:      "Spawn",
+			Translate: []*collada.Translate{s.spawn},
+			Rotate:    []*collada.Rotate{s.look},
+		}}
+		w, problems := importDocument(s.asset, scene, nil)
+		if len(problems) != 0 {
+			t.Errorf("%s: problems %v", s.asset.UpAxis, problems)
+		}
+		horizon := w.Network.Horizons[1]
+		if !horizon.Center.ApproxEqual(glm.Vec4d{1, 2, 3, 1}) || !horizon.Normal.ApproxEqual(glm.Vec4d{1, 0, 0, 0}) {
+			t.Errorf("%s: portal at %v facing %v, want at (1, 2, 3) facing +x", s.asset.UpAxis, horizon.Center, horizon.Normal)
+		}
+		if !approxScale(horizon.Scale, UnitQuad.Scale) {
+			t.Errorf("%s: portal scaled to %v, want %v", s.asset.UpAxis, horizon.Scale, UnitQuad.Scale)
+		}
+		if !w.Spawn.Position.ApproxEqual(glm.Vec4d{0, 1, 4, 1}) || !w.Spawn.Facing.ApproxEqual(glm.Vec4d{0, 0, -1, 0}) {
+			t.Errorf("%s: spawn %v, want at (0, 1, 4) facing -z", s.asset.UpAxis, w.Spawn)
+		}
+	}
+}
+
+func approxScale(a, b glm.Vec4d) bool {
+	return a.Sub(b).Len() < 1e-9
+}
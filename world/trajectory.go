@@ -0,0 +1,62 @@
+package world
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// StateRecord is a line of trajectory output with the player after a tick.
+type StateRecord struct {
+	Type        string     `json:"type"`
+	Tick        int        `json:"tick"`
+	Time        float64    `json:"time"`
+	Position    [3]float64 `json:"position"`
+	Velocity    [3]float64 `json:"velocity"`
+	Orientation [4]float64 `json:"orientation"` // w, x, y, z
+}
+
+// CrossingRecord is a line of trajectory output for a portal crossing.
+type CrossingRecord struct {
+	Type     string     `json:"type"`
+	Tick     int        `json:"tick"`
+	Time     float64    `json:"time"`
+	Entry    int        `json:"entry"`
+	Exit     int        `json:"exit"`
+	Position [3]float64 `json:"position"`
+}
+
+// Run simulates the given number of ticks, writing the player's state after
+// each and every portal crossing to out as json lines.
+func (w *World) Run(ticks int, out io.Writer) error {
+	var err error
+	encoder := json.NewEncoder(out)
+	previous := w.OnCross
+	defer func() { w.OnCross = previous }()
+	w.OnCross = func(c Crossing) {
+		if previous != nil {
+			previous(c)
+		}
+		if err == nil {
+			p := c.Position
+			time := float64(c.Tick) * w.Constants.TimeStep
+			err = encoder.Encode(CrossingRecord{"crossing", c.Tick, time, c.Entry, c.Exit, [3]float64{p[0], p[1], p[2]}})
+		}
+	}
+	for i := 0; i < ticks && err == nil; i++ {
+		tick := w.Tick
+		w.Step()
+		if err == nil {
+			p := w.Player
+			q := p.Orientation
+			err = encoder.Encode(StateRecord{
+				"state",
+				tick,
+				w.Elapsed(),
+				[3]float64{p.Position[0], p.Position[1], p.Position[2]},
+				[3]float64{p.Velocity[0], p.Velocity[1], p.Velocity[2]},
+				[4]float64{q.W, q.V[0], q.V[1], q.V[2]},
+			})
+		}
+	}
+	return err
+}
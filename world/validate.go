@@ -0,0 +1,155 @@
+package world
+
+import (
+	"fmt"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"math"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is something wrong with a level, found while loading it.
+type Problem struct {
+	Severity Severity
+	Node     string // the node at fault, if there is one
+	Portal   int    // the portal at fault, or -1
+	Message  string
+}
+
+func (p Problem) String() string {
+	s := p.Severity.String() + ":"
+	if p.Node != "" {
+		s += " " + p.Node + ":"
+	}
+	if p.Portal >= 0 {
+		s += fmt.Sprintf(" portal %d:", p.Portal)
+	}
+	return s + " " + p.Message
+}
+
+type Problems []Problem
+
+func (ps *Problems) add(severity Severity, node string, id int, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{severity, node, id, fmt.Sprintf(format, args...)})
+}
+
+func (ps Problems) Errors() Problems {
+	errors := Problems{}
+	for _, p := range ps {
+		if p.Severity == Error {
+			errors = append(errors, p)
+		}
+	}
+	return errors
+}
+
+// Err is an error listing the error problems, or nil if there are none.
+func (ps Problems) Err() error {
+	errors := ps.Errors()
+	if len(errors) == 0 {
+		return nil
+	}
+	lines := make([]string, len(errors))
+	for i, p := range errors {
+		lines[i] = p.String()
+	}
+	return fmt.Errorf("level has %d errors:\n%s", len(errors), strings.Join(lines, "\n"))
+}
+
+// perpendicularLimit is the largest cosine allowed between a portal's normal
+// and its PlaneV.
+const perpendicularLimit = 1e-3
+
+// ValidateTransform checks the node transform a unit portal quad is placed
+// with. Apply would quietly square up a sheared or mirrored transform.
+func ValidateTransform(node string, id int, m glm.Mat4d) Problems {
+	problems := Problems{}
+	x := m.Mul4x1(UnitQuad.PlaneV)
+	y := m.Mul4x1(UnitQuad.Up())
+	z := m.Mul4x1(UnitQuad.Normal)
+	if x.Len() < 1e-9 || y.Len() < 1e-9 || z.Len() < 1e-9 {
+		problems.add(Error, node, id, "zero scale %v", glm.Vec3d{x.Len(), y.Len(), z.Len()})
+		return problems
+	}
+	if portal.Cross3Dv(x, y).Dot(z) < 0 {
+		problems.add(Error, node, id, "negative scale mirrors the portal")
+	}
+	if c := math.Abs(x.Normalize().Dot(z.Normalize())); c > perpendicularLimit {
+		problems.add(Error, node, id, "normal is not perpendicular to PlaneV (cosine %.3g)", c)
+	}
+	return problems
+}
+
+// ValidateQuad checks a horizon's frame and extents.
+func ValidateQuad(node string, id int, q portal.Quad) Problems {
+	problems := Problems{}
+	for i := 0; i < 3; i++ {
+		if !(q.Scale[i] > 0) {
+			problems.add(Error, node, id, "scale %v is not positive", q.Scale)
+			break
+		}
+	}
+	if q.Normal.Len() < 1e-9 || q.PlaneV.Len() < 1e-9 {
+		problems.add(Error, node, id, "degenerate normal %v or PlaneV %v", q.Normal, q.PlaneV)
+	} else if c := math.Abs(q.Normal.Normalize().Dot(q.PlaneV.Normalize())); c > perpendicularLimit {
+		problems.add(Error, node, id, "normal is not perpendicular to PlaneV (cosine %.3g)", c)
+	}
+	return problems
+}
+
+// ValidateNetwork checks every horizon, that every link leads to another
+// horizon which links back, and that no two horizons overlap. names gives
+// where each portal id was declared, and oneWay the portals whose exits need
+// not lead back. A horizon without a link is an exit only, which something
+// should lead to. Overlapping horizons still work, so they are only warned of.
+func ValidateNetwork(n portal.Network, names map[int]string, oneWay map[int]bool) Problems {
+	problems := Problems{}
+	ids := n.Ids()
+	entered := make(map[int]bool)
+	for _, exit := range n.Links {
+		entered[exit] = true
+	}
+	for _, id := range ids {
+		problems = append(problems, ValidateQuad(names[id], id, n.Horizons[id])...)
+		exit, linked := n.Links[id]
+		if !linked {
+			if !entered[id] {
+				problems.add(Warning, names[id], id, "has no link and no portal leads to it")
+			}
+			continue
+		}
+		if exit == id {
+			problems.add(Error, names[id], id, "links to itself")
+			continue
+		}
+		if _, ok := n.Horizons[exit]; !ok {
+			problems.add(Error, names[id], id, "no exit portal %d", exit)
+			continue
+		}
+		if back, ok := n.Links[exit]; (!ok || back != id) && !oneWay[id] {
+			problems.add(Warning, names[id], id, "one way link: portal %d does not lead back", exit)
+		}
+	}
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			if portal.Overlaps(n.Horizons[a], n.Horizons[b]) {
+				problems.add(Warning, names[a], a, "overlaps portal %d (%s)", b, names[b])
+			}
+		}
+	}
+	return problems
+}
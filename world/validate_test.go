@@ -0,0 +1,155 @@
+package world
+
+import (
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"strings"
+	"testing"
+)
+
+func horizonAt(x float64) portal.Quad {
+	q := UnitQuad
+	q.Center = glm.Vec4d{x, 1, -5, 1}
+	return q
+}
+
+// find returns the problems about portal id whose message contains text.
+func find(ps Problems, id int, text string) Problems {
+	found := Problems{}
+	for _, p := range ps {
+		if p.Portal == id && strings.Contains(p.Message, text) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+func expect(t *testing.T, ps Problems, id int, text string, severity Severity) {
+	found := find(ps, id, text)
+	if len(found) != 1 {
+		t.Errorf("portal %d: want one %q problem in %v", id, text, ps)
+		return
+	}
+	if found[0].Severity != severity {
+		t.Errorf("portal %d: %q is a %v, want a %v", id, text, found[0].Severity, severity)
+	}
+}
+
+func network(horizons map[int]float64, links map[int]int) portal.Network {
+	n := portal.NewNetwork()
+	for id, x := range horizons {
+		n.Horizons[id] = horizonAt(x)
+	}
+	for id, exit := range links {
+		n.Links[id] = exit
+	}
+	return n
+}
+
+func TestValidateNetworkLinked(t *testing.T) {
+	n := network(map[int]float64{1: 0, 2: 5}, nil)
+	n.Link(1, 2)
+	if ps := ValidateNetwork(n, nil, nil); len(ps) != 0 {
+		t.Errorf("problems with a linked pair: %v", ps)
+	}
+}
+
+func TestValidateNetworkUnlinked(t *testing.T) {
+	n := network(map[int]float64{1: 0, 2: 5, 3: 10}, map[int]int{1: 2})
+	ps := ValidateNetwork(n, nil, nil)
+	expect(t, ps, 3, "no link", Warning)
+	if len(find(ps, 2, "no link")) != 0 {
+		t.Error("an exit that is led to is reported as unlinked")
+	}
+}
+
+func TestValidateNetworkSelfLink(t *testing.T) {
+	n := network(map[int]float64{1: 0}, map[int]int{1: 1})
+	ps := ValidateNetwork(n, map[int]string{1: "Portal_1_1"}, nil)
+	expect(t, ps, 1, "itself", Error)
+	if ps[0].Node != "Portal_1_1" {
+		t.Errorf("problem names node %q", ps[0].Node)
+	}
+}
+
+func TestValidateNetworkMissingExit(t *testing.T) {
+	n := network(map[int]float64{1: 0}, map[int]int{1: 7})
+	expect(t, ValidateNetwork(n, nil, nil), 1, "no exit portal 7", Error)
+}
+
+func TestValidateNetworkOneWay(t *testing.T) {
+	n := network(map[int]float64{1: 0, 2: 5, 3: 10}, map[int]int{1: 2, 2: 3, 3: 2})
+	expect(t, ValidateNetwork(n, nil, nil), 1, "one way", Warning)
+	if ps := find(ValidateNetwork(n, nil, map[int]bool{1: true}), 1, "one way"); len(ps) != 0 {
+		t.Errorf("a portal declared one way is reported: %v", ps)
+	}
+}
+
+func TestValidateNetworkOverlap(t *testing.T) {
+	n := network(map[int]float64{1: 0, 2: 0.5, 3: 2.5}, nil)
+	n.Link(1, 2)
+	n.Links[3] = 1
+	ps := ValidateNetwork(n, nil, nil)
+	expect(t, ps, 1, "overlaps portal 2", Warning)
+	if len(find(ps, 1, "overlaps portal 3"))+len(find(ps, 2, "overlaps portal 3")) != 0 {
+		t.Errorf("horizons which only touch reported as overlapping: %v", ps)
+	}
+	if ps.Err() != nil {
+		t.Errorf("overlap stops the level loading: %v", ps.Err())
+	}
+}
+
+func TestValidateTransform(t *testing.T) {
+	transforms := []struct {
+		name string
+		m    glm.Mat4d
+		want string
+	}{
+		{"zero scale", glm.Scale3Dd(1, 0, 1), "zero scale"},
+		{"mirrored", glm.Scale3Dd(-1, 1, 1), "mirrors"},
+		{"sheared", glm.Mat4d{1, 0, 0, 0, 0, 1, 0, 0, 0.5, 0, 1, 0, 0, 0, 0, 1}, "perpendicular"},
+	}
+	for _, c := range transforms {
+		ps := ValidateTransform(c.name, 1, c.m)
+		if len(ps) != 1 || !strings.Contains(ps[0].Message, c.want) || ps[0].Severity != Error {
+			t.Errorf("%s: problems %v, want one %q error", c.name, ps, c.want)
+		}
+	}
+	m := glm.Translate3Dd(1, 2, 3).Mul4(glm.HomogRotate3DYd(30)).Mul4(glm.Scale3Dd(2, 3, 1))
+	if ps := ValidateTransform("placed", 1, m); len(ps) != 0 {
+		t.Errorf("problems with a rotated and scaled portal: %v", ps)
+	}
+}
+
+func TestFinishLeavesOutBrokenPortals(t *testing.T) {
+	w := New(DefaultConstants)
+	l := w.newLevelLoader(nil)
+	one, two, self, missing := 1, 2, 3, 9
+	l.addPortal("Portal_1_2", 1, horizonAt(0), &two, portal.PreserveSpeed)
+	l.addPortal("Portal_2_1", 2, horizonAt(5), &one, portal.PreserveSpeed)
+	l.addPortal("Portal_3_3", 3, horizonAt(10), &self, portal.PreserveSpeed)
+	l.addPortal("Portal_4_9", 4, horizonAt(15), &missing, portal.PreserveSpeed)
+	l.addPortal("Portal_1_2.001", 1, horizonAt(20), &two, portal.PreserveSpeed)
+	_, ps := l.finish()
+	if len(ps.Errors()) != 3 {
+		t.Errorf("want errors for the self link, missing exit and duplicate, got %v", ps)
+	}
+	expect(t, ps, 1, "duplicate", Error)
+	_, ids := w.Network.Portals()
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("portals %v built, want only 1 and 2", ids)
+	}
+}
+
+func TestProblemsErr(t *testing.T) {
+	ps := Problems{}
+	ps.add(Warning, "a", -1, "just a warning")
+	if ps.Err() != nil {
+		t.Error("warnings are an error")
+	}
+	ps.add(Error, "b", 4, "broken %d", 4)
+	err := ps.Err()
+	if err == nil || !strings.Contains(err.Error(), "error: b: portal 4: broken 4") {
+		t.Errorf("error %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Package world holds the game state and rules: the player, the portal
+// network, the level's collision data and the fixed step simulation. It does
+// no drawing, so it runs the same with or without a window.
+package world
+
+import (
+	"fmt"
+	gtk "github.com/GlenKelley/go-glutil"
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"time"
+)
+
+type Constants struct {
+	PlayerMovementLimit        float64
+	PlayerImpulseMomentumLimit float64
+	Gravity                    float64
+	PlayerPanSensitivity       float64 // turn per degree of view the mouse moves across
+	PlayerFOV                  float64 // vertical field of view in degrees
+	PortalWidth                float64
+	PortalHeight               float64
+	PlayerReach                float64
+	PlayerHeight               float64
+	PlayerRadius               float64
+	TimeStep                   float64 // seconds per simulation tick
+	UprightTime                float64 // seconds to undo roll after a crossing
+}
+
+var DefaultConstants = Constants{5, 5, -9.8, 7, 70, 1.2, 2, 50, 1, 0.25, 1.0 / 60, 0.5}
+
+// Crossing is the player passing through portal Entry and out of Exit.
+type Crossing struct {
+	Tick     int
+	Entry    int
+	Exit     int
+	Position glm.Vec4d // after the crossing
+}
+
+type World struct {
+	Constants  Constants
+	Level      portal.Scene
+	Network    portal.Network
+	PortalIds  []int // network id of each of Level.Portals
+	GunPortals [2]int
+	Colliders  map[string]Collider
+	Gravity    []GravityRegion // later regions override earlier ones
+	Bodies     []*Body
+	Spawn      Spawn
+	Player     Player
+	UIState    UIState
+	Input      InputLog
+	Tick       int       // the next tick to simulate
+	Inception  glm.Mat4d // world coordinates into the coordinates the player started in
+
+	OnCross   func(Crossing)
+	OnPortals func() // called after the portals change
+	OnError   func(error)
+}
+
+func New(c Constants) *World {
+	return &World{
+		Constants: c,
+		Network:   portal.NewNetwork(),
+		Colliders: map[string]Collider{},
+		Spawn:     DefaultSpawn,
+		Inception: glm.Ident4d(),
+	}
+}
+
+func (w *World) TimeStep() time.Duration {
+	return time.Duration(w.Constants.TimeStep * float64(time.Second))
+}
+
+// Elapsed is the simulated time in seconds.
+func (w *World) Elapsed() float64 {
+	return float64(w.Tick) * w.Constants.TimeStep
+}
+
+func (w *World) report(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+// Step advances the simulation by one tick, after applying the input due.
+func (w *World) Step() {
+	w.ApplyInput()
+	deltaT := w.Constants.TimeStep
+
+	if !w.UIState.Impulse.ApproxEqual(glm.Vec4d{}) {
+		regulatedImpulse := w.UIState.Impulse.Normalize().Mul(w.Constants.PlayerImpulseMomentumLimit * w.Player.Size)
+		viewAdjustedImpulse := gtk.ToHomogVec4D(w.Player.OrientationH.Rotate(gtk.ToVec3D(regulatedImpulse)))
+		w.Player.Velocity = w.Player.Velocity.Add(viewAdjustedImpulse)
+		w.UIState.Impulse = glm.Vec4d{}
+	}
+
+	aggregateVelocity := w.Player.Velocity
+	if !w.UIState.Movement.ApproxEqual(glm.Vec4d{}) {
+		regulatedMovement := w.UIState.Movement.Normalize().Mul(w.Constants.PlayerMovementLimit * w.Player.Size)
+		viewAdjustedMovement := gtk.ToHomogVec4D(w.Player.OrientationH.Rotate(gtk.ToVec3D(regulatedMovement)))
+		aggregateVelocity = aggregateVelocity.Add(viewAdjustedMovement)
+	}
+
+	dp := aggregateVelocity.Mul(deltaT)
+
+	if i, ok := w.Crossed(w.Player.Position, dp); ok {
+		p := w.Level.Portals[i]
+		passage := p.Passage()
+		w.Player.Cross(passage)
+		w.Player.Righting = w.Constants.UprightTime
+		dp = passage.Vector(dp)
+		w.Inception = w.Inception.Mul4(p.Transform)
+		if w.OnCross != nil {
+			id := w.PortalIds[i]
+			w.OnCross(Crossing{w.Tick, id, w.Network.Links[id], w.Player.Position})
+		}
+	}
+
+	moved, normals := w.Level.Sweep(w.Player.Capsule(w.Constants), dp)
+	w.Player.Position = w.Player.Position.Add(moved)
+	normals = append(normals, w.Level.Touching(w.Player.Capsule(w.Constants), groundSkin*w.Player.Size)...)
+	w.Player.Velocity = portal.Slide(w.Player.Velocity, normals)
+
+	// apply gravity if the player is off the ground
+	if !w.Player.Grounded(normals) {
+		g := w.GravityAt(w.Player.Position)
+		w.Player.Velocity = w.Player.Velocity.Add(g.Mul(deltaT))
+	}
+	w.Reorient(deltaT)
+	w.StepBodies(deltaT)
+	w.Tick++
+}
+
+// Cameraview maps world space into the player's eye space.
+func (w *World) Cameraview() glm.Mat4d {
+	p := w.Player.Position
+	translate := glm.Translate3Dd(-p[0], -p[1], -p[2])
+	rotation := w.Player.Orientation.Conjugate().Mat4()
+	return rotation.Mul4(translate)
+}
+
+// Idle is true when nothing is moving and no input is waiting.
+func (w *World) Idle() bool {
+	for _, b := range w.Bodies {
+		if !b.Velocity.ApproxEqual(glm.Vec4d{}) {
+			return false
+		}
+	}
+	return len(w.Input.Pending) == 0 &&
+		w.UIState.Impulse.ApproxEqual(glm.Vec4d{}) &&
+		w.UIState.Movement.ApproxEqual(glm.Vec4d{}) &&
+		w.Player.Velocity.ApproxEqual(glm.Vec4d{})
+}
+
+// Pan turns the view by a mouse movement delta, in screen fractions.
+func (w *World) Pan(delta glm.Vec2d) {
+	// in degrees, as QuatRotated takes them
+	theta := delta.Mul(w.Constants.PlayerFOV * w.Constants.PlayerPanSensitivity)
+
+	turnV := glm.QuatRotated(theta[1], gtk.ToVec3D(w.Player.TiltAxis))
+	turnH := glm.QuatRotated(-theta[0], gtk.ToVec3D(w.Player.PanAxis))
+
+	w.Player.OrientationH = turnH.Mul(w.Player.OrientationH)
+	w.Player.Orientation = turnH.Mul(w.Player.Orientation).Mul(turnV)
+}
+
+func (w *World) RebuildPortals() {
+	w.Level.Portals, w.PortalIds = w.Network.Portals()
+	if w.OnPortals != nil {
+		w.OnPortals()
+	}
+}
+
+// PlacePortal moves portal id onto the surface the player is looking at.
+func (w *World) PlacePortal(id int) error {
+	forward := gtk.ToHomogVec4D(w.Player.Orientation.Rotate(glm.Vec3d{0, 0, -1}))
+	hits := w.Level.Raycast(w.Player.Position, forward, w.Constants.PlayerReach, len(w.Level.Portals))
+	extents := glm.Vec2d{w.Constants.PortalWidth / 2, w.Constants.PortalHeight / 2}
+	err := w.Network.Place(&w.Level, id, hits, w.Player.PanAxis, extents, portal.Rectangle{})
+	if err != nil {
+		return fmt.Errorf("cannot place portal: %v", err)
+	}
+	w.RebuildPortals()
+	return nil
+}
+
+// SetModelTransform moves a model of the level, and its collision triangles.
+func (w *World) SetModelTransform(name string, transform glm.Mat4d) error {
+	collider, ok := w.Colliders[name]
+	if !ok {
+		return fmt.Errorf("no model named %s", name)
+	}
+	collider.Model.Transform = transform
+	return w.Level.Index.Update(collider.Group, collider.World())
+}
@@ -0,0 +1,52 @@
+package world
+
+import (
+	"github.com/GlenKelley/portal"
+	glm "github.com/Jragonmiris/mathgl"
+	"testing"
+)
+
+// floorWorld is an empty level with a floor at y=0 and the player standing
+// on it.
+func floorWorld() *World {
+	w := New(DefaultConstants)
+	a := glm.Vec4d{-50, 0, -50, 1}
+	b := glm.Vec4d{-50, 0, 50, 1}
+	c := glm.Vec4d{50, 0, 50, 1}
+	d := glm.Vec4d{50, 0, -50, 1}
+	w.Level.Triangles = []portal.Triangle{{a, b, c, "floor"}, {a, c, d, "floor"}}
+	w.Player = NewPlayer(glm.Vec4d{0, w.Constants.PlayerHeight, 0, 1})
+	return w
+}
+
+func TestPlayerStaysGrounded(t *testing.T) {
+	w := floorWorld()
+	start := w.Player.Position
+	for i := 0; i < 120; i++ {
+		w.Step()
+		touching := w.Level.Touching(w.Player.Capsule(w.Constants), groundSkin)
+		if !w.Player.Grounded(touching) {
+			t.Fatalf("tick %d: player at %v is off the ground", w.Tick, w.Player.Position)
+		}
+		if !w.Player.Velocity.ApproxEqual(glm.Vec4d{}) {
+			t.Fatalf("tick %d: resting player has velocity %v", w.Tick, w.Player.Velocity)
+		}
+	}
+	if d := w.Player.Position.Sub(start).Len(); d > 1e-9 {
+		t.Errorf("resting player drifted %v", d)
+	}
+}
+
+func TestPlayerLands(t *testing.T) {
+	w := floorWorld()
+	w.Player.Position[1] += 1
+	for i := 0; i < 120; i++ {
+		w.Step()
+	}
+	if h := w.Player.Position[1]; h < w.Constants.PlayerHeight-1e-6 || h > w.Constants.PlayerHeight+groundSkin {
+		t.Errorf("player came to rest at height %v, want %v", h, w.Constants.PlayerHeight)
+	}
+	if !w.Player.Velocity.ApproxEqual(glm.Vec4d{}) {
+		t.Errorf("landed player has velocity %v", w.Player.Velocity)
+	}
+}